@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/altipla-consulting/errors"
@@ -25,13 +30,56 @@ var cmdCompose = &cobra.Command{
 }
 
 func init() {
-	var flagSentry, flagFile string
+	var flagSentry, flagFile, flagShip, flagRegistry string
 	var flagContainers []string
+	var flagRender, flagDiff bool
 	cmdCompose.Flags().StringVar(&flagSentry, "sentry", "", "Name of the sentry project to configure.")
 	cmdCompose.Flags().StringVar(&flagFile, "file", "docker-compose.prod.yml", "Path to the Docker Compose file to deploy.")
 	cmdCompose.Flags().StringSliceVarP(&flagContainers, "container", "c", nil, "Name of the container to deploy. Can be specified multiple times.")
+	cmdCompose.Flags().StringVar(&flagShip, "ship", shipRemote, "How images reach the remote machine: remote builds them there, registry builds locally and pushes to --registry, ssh builds locally and streams them through SSH.")
+	cmdCompose.Flags().BoolVar(&flagRender, "render", false, "Print the expanded Docker Compose file with secrets masked instead of deploying it.")
+	cmdCompose.Flags().BoolVar(&flagDiff, "diff", false, "Show the changes of each service instead of deploying them. Changes are compared with the copy of the file stored in the remote machine by the last deployment of every container with wave, not with the running containers.")
+	cmdCompose.Flags().StringVar(&flagRegistry, "registry", "", "Registry prefix where images are pushed when using --ship=registry. Example: eu.gcr.io/foo-project.")
 
 	cmdCompose.RunE = func(cmd *cobra.Command, args []string) error {
+		switch flagShip {
+		case shipRemote, shipSSH:
+		case shipRegistry:
+			if flagRegistry == "" {
+				return errors.Errorf("missing --registry flag to ship images")
+			}
+		default:
+			return errors.Errorf("unknown --ship mode: %s", flagShip)
+		}
+
+		content, err := os.ReadFile(flagFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var secrets []string
+		var mapErr error
+		var mapFn = func(placeholder string) string {
+			val, err := replaceEnv(cmd.Context(), flagSentry, placeholder)
+			if err != nil {
+				mapErr = errors.Trace(err)
+				return "[ERROR]"
+			}
+			if isSecretPlaceholder(placeholder) && val != "" {
+				secrets = append(secrets, val)
+			}
+			return val
+		}
+		content = []byte(os.Expand(string(content), mapFn))
+		if mapErr != nil {
+			return errors.Trace(mapErr)
+		}
+		masker := newSecretsMasker(secrets)
+
+		if flagRender {
+			fmt.Print(masker.Replace(string(content)))
+			return nil
+		}
+
 		logger := slog.With(slog.String("machine", args[0]))
 		logger.Info("Deploy to remote machine with Docker Compose", slog.String("version", query.Version(cmd.Context())))
 
@@ -72,39 +120,50 @@ func init() {
 			return errors.Trace(err)
 		}
 
-		content, err := os.ReadFile(flagFile)
-		if err != nil {
-			return errors.Trace(err)
-		}
-		var mapErr error
-		var mapFn = func(placeholder string) string {
-			val, err := replaceEnv(cmd.Context(), flagSentry, placeholder)
-			if err != nil {
-				mapErr = errors.Trace(err)
-				return "[ERROR]"
-			}
-			return val
-		}
-		content = []byte(os.Expand(string(content), mapFn))
-		if mapErr != nil {
-			return errors.Trace(mapErr)
-		}
-
 		tmpFile := filepath.Join(filepath.Dir(flagFile), "docker-compose.prod-tmpl.yml")
 		if err := os.WriteFile(tmpFile, content, 0600); err != nil {
 			return errors.Trace(err)
 		}
 		defer os.Remove(tmpFile)
 
-		logger.Info("Building remote containers")
-		build := exec.CommandContext(cmd.Context(), "docker", "compose", "-f", tmpFile, "build")
-		build.Stderr = os.Stderr
-		build.Stdout = os.Stdout
-		build.Env = os.Environ()
-		build.Env = append(build.Env, "DOCKER_HOST=ssh://jenkins@"+args[0])
-		if err := build.Run(); err != nil {
+		project, err := composeConfig(cmd.Context(), tmpFile, "")
+		if err != nil {
 			return errors.Trace(err)
 		}
+		projectName, _ := project["name"].(string)
+
+		if flagDiff {
+			logger.Info("Comparing with the deployed configuration")
+			rendered, err := composeConfig(cmd.Context(), tmpFile, projectName, composeDiffFlags...)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			deployed, err := deployedComposeConfig(cmd.Context(), args[0], projectName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if deployed == nil {
+				logger.Warn("No configuration stored by a previous deployment of every container with wave, all the services are reported as new")
+			}
+			printComposeDiff(os.Stdout, masker, deployed, rendered)
+			return nil
+		}
+
+		if flagShip == shipRemote {
+			logger.Info("Building remote containers")
+			build := exec.CommandContext(cmd.Context(), "docker", "compose", "-f", tmpFile, "build")
+			build.Stderr = os.Stderr
+			build.Stdout = os.Stdout
+			build.Env = os.Environ()
+			build.Env = append(build.Env, "DOCKER_HOST=ssh://jenkins@"+args[0])
+			if err := build.Run(); err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			if err := shipImages(cmd.Context(), logger, tmpFile, args[0], flagShip, flagRegistry); err != nil {
+				return errors.Trace(err)
+			}
+		}
 
 		logger.Info("Sending container changes to the remote machine")
 		cmdargs := append([]string{"compose", "-f", tmpFile, "up", "-d", "--remove-orphans"}, flagContainers...)
@@ -117,10 +176,360 @@ func init() {
 			return errors.Trace(err)
 		}
 
+		// Only a deployment of every container leaves the machine running the whole file.
+		if len(flagContainers) > 0 {
+			logger.Info("Keeping the stored configuration, only some containers were deployed")
+			return nil
+		}
+
+		// Store the rendered file, not the one rewritten to reference the shipped images,
+		// so the next diff compares the same kind of configuration.
+		logger.Info("Storing deployed configuration in the remote machine")
+		store := exec.CommandContext(cmd.Context(), "ssh", "jenkins@"+args[0], fmt.Sprintf("mkdir -p %[1]s && umask 077 && cat > %[1]s/%[2]s.yml", remoteComposeDir, projectName))
+		store.Stdin = bytes.NewReader(content)
+		store.Stdout = os.Stdout
+		store.Stderr = os.Stderr
+		if err := store.Run(); err != nil {
+			return errors.Trace(err)
+		}
+
 		return nil
 	}
 }
 
+// remoteComposeDir is the folder, relative to the home of the remote user, where
+// the last deployed configuration of each project is stored to diff against it.
+const remoteComposeDir = ".wave/compose"
+
+// composeDiffFlags normalize the configuration without reading the files it
+// references, that only exist in the machine that deploys.
+var composeDiffFlags = []string{"--no-path-resolution", "--no-env-resolution"}
+
+func composeConfig(ctx context.Context, filename, projectName string, flags ...string) (map[string]any, error) {
+	args := []string{"compose", "-f", filename}
+	if projectName != "" {
+		args = append(args, "-p", projectName)
+	}
+	args = append(args, "config", "--format", "json")
+	args = append(args, flags...)
+	config := exec.CommandContext(ctx, "docker", args...)
+	config.Stderr = os.Stderr
+	output, err := config.Output()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var project map[string]any
+	if err := json.Unmarshal(output, &project); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return project, nil
+}
+
+// deployedComposeConfig normalizes the configuration stored by the last deployment
+// of every container running `docker compose config` in the remote machine. It
+// returns nil if the project has never been fully deployed with wave before.
+func deployedComposeConfig(ctx context.Context, machine, projectName string) (map[string]any, error) {
+	filename := fmt.Sprintf("%s/%s.yml", remoteComposeDir, projectName)
+	script := fmt.Sprintf("test -f %[1]s || exit 0; docker compose -f %[1]s -p %[2]s config --format json %[3]s", filename, projectName, strings.Join(composeDiffFlags, " "))
+	fetch := exec.CommandContext(ctx, "ssh", "jenkins@"+machine, script)
+	fetch.Stderr = os.Stderr
+	output, err := fetch.Output()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+	var project map[string]any
+	if err := json.Unmarshal(output, &project); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return project, nil
+}
+
+func printComposeDiff(w io.Writer, masker *strings.Replacer, deployed, project map[string]any) {
+	before, _ := deployed["services"].(map[string]any)
+	after, _ := project["services"].(map[string]any)
+
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changed bool
+	for _, name := range names {
+		old := make(map[string]string)
+		flattenConfig(old, "", before[name])
+		current := make(map[string]string)
+		flattenConfig(current, "", after[name])
+
+		keys := make(map[string]bool)
+		for key := range old {
+			keys[key] = true
+		}
+		for key := range current {
+			keys[key] = true
+		}
+		var lines []string
+		for _, key := range sortedKeys(keys) {
+			prev, inOld := old[key]
+			next, inCurrent := current[key]
+			switch {
+			case !inCurrent:
+				lines = append(lines, fmt.Sprintf("    - %s: %s", key, masker.Replace(prev)))
+			case !inOld:
+				lines = append(lines, fmt.Sprintf("    + %s: %s", key, masker.Replace(next)))
+			case prev != next:
+				lines = append(lines, fmt.Sprintf("    ~ %s: %s -> %s", key, masker.Replace(prev), masker.Replace(next)))
+			}
+		}
+
+		switch {
+		case before[name] == nil:
+			fmt.Fprintf(w, "+ service %s\n", name)
+		case after[name] == nil:
+			fmt.Fprintf(w, "- service %s\n", name)
+		case len(lines) > 0:
+			fmt.Fprintf(w, "~ service %s\n", name)
+		default:
+			continue
+		}
+		changed = true
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if !changed {
+		fmt.Fprintln(w, "No changes in the services configuration.")
+	}
+}
+
+// flattenConfig converts a nested configuration value to a map of dotted paths to
+// its JSON encoded leaf values.
+func flattenConfig(dst map[string]string, prefix string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenConfig(dst, key, child)
+		}
+
+	case []any:
+		for i, child := range v {
+			flattenConfig(dst, fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+
+	case nil:
+		if prefix != "" {
+			dst[prefix] = "null"
+		}
+
+	default:
+		encoded, _ := json.Marshal(v)
+		dst[prefix] = string(encoded)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isSecretPlaceholder reports if the value of the placeholder comes from the environment
+// and should not be printed in the output.
+func isSecretPlaceholder(placeholder string) bool {
+	switch {
+	case placeholder == "VERSION", placeholder == "IMAGE_TAG":
+		return false
+	case placeholder == "SENTRY_DSN", strings.HasPrefix(placeholder, "SENTRY_DSN("):
+		return false
+	}
+	return true
+}
+
+func newSecretsMasker(secrets []string) *strings.Replacer {
+	// Replace longer values first in case some secret contains another one.
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	var oldnew []string
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, "******")
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+const (
+	shipRemote   = "remote"
+	shipRegistry = "registry"
+	shipSSH      = "ssh"
+)
+
+// shipImages builds the containers locally, sends them to the remote machine and
+// rewrites the compose file to reference the exact images that were sent. The remote
+// machine never builds anything afterwards.
+func shipImages(ctx context.Context, logger *slog.Logger, tmpFile, machine, mode, registry string) error {
+	logger.Info("Building local containers")
+	build := exec.CommandContext(ctx, "docker", "compose", "-f", tmpFile, "build")
+	build.Stderr = os.Stderr
+	build.Stdout = os.Stdout
+	if err := build.Run(); err != nil {
+		return errors.Trace(err)
+	}
+
+	project, err := composeConfig(ctx, tmpFile, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = overrideImages(project, mode, func(service, local string) (string, error) {
+		switch mode {
+		case shipRegistry:
+			return pushImage(ctx, logger, service, local, registry)
+		case shipSSH:
+			return streamImage(ctx, logger, service, local, sshImage(project, service, query.VersionImageTag(ctx)), machine)
+		}
+		return "", errors.Errorf("unknown --ship mode: %s", mode)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	content, err := json.MarshalIndent(project, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.WriteFile(tmpFile, content, 0600))
+}
+
+// overrideImages replaces the build of every service of the project with the image
+// returned by ship after sending the local image to the remote machine.
+func overrideImages(project map[string]any, mode string, ship func(service, local string) (string, error)) error {
+	services, _ := project["services"].(map[string]any)
+	projectName, _ := project["name"].(string)
+
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service, ok := services[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := service["build"]; !ok {
+			continue
+		}
+
+		local, _ := service["image"].(string)
+		if local == "" {
+			local = projectName + "-" + name
+		}
+		ref, err := ship(name, local)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		delete(service, "build")
+		service["image"] = ref
+		if mode == shipSSH {
+			// The image only exists in the remote machine, it cannot be pulled.
+			service["pull_policy"] = "never"
+		}
+	}
+	return nil
+}
+
+// sshImage returns the stable tag of an image streamed to the remote machine. It
+// includes the project to avoid collisions between projects with services of the
+// same name in the machine.
+func sshImage(project map[string]any, service, tag string) string {
+	projectName, _ := project["name"].(string)
+	return projectName + "-" + service + ":" + tag
+}
+
+func pushImage(ctx context.Context, logger *slog.Logger, service, local, registry string) (string, error) {
+	image := registry + "/" + service + ":" + query.VersionImageTag(ctx)
+	logger.Info("Push container to the registry", slog.String("service", service), slog.String("image", image))
+
+	tag := exec.CommandContext(ctx, "docker", "tag", local, image)
+	tag.Stdout = os.Stdout
+	tag.Stderr = os.Stderr
+	if err := tag.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	push := exec.CommandContext(ctx, "docker", "push", image)
+	push.Stdout = os.Stdout
+	push.Stderr = os.Stderr
+	if err := push.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	inspect := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{json .RepoDigests}}", image)
+	inspect.Stderr = os.Stderr
+	output, err := inspect.Output()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var digests []string
+	if err := json.Unmarshal(output, &digests); err != nil {
+		return "", errors.Trace(err)
+	}
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, registry+"/"+service+"@") {
+			return digest, nil
+		}
+	}
+	return "", errors.Errorf("cannot find the pushed digest of image %s", image)
+}
+
+func streamImage(ctx context.Context, logger *slog.Logger, service, local, image, machine string) (string, error) {
+	logger.Info("Stream container to the remote machine", slog.String("service", service), slog.String("image", image))
+
+	tag := exec.CommandContext(ctx, "docker", "tag", local, image)
+	tag.Stdout = os.Stdout
+	tag.Stderr = os.Stderr
+	if err := tag.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	save := exec.CommandContext(ctx, "docker", "save", image)
+	save.Stderr = os.Stderr
+	load := exec.CommandContext(ctx, "ssh", "jenkins@"+machine, "docker", "load")
+	load.Stdout = os.Stdout
+	load.Stderr = os.Stderr
+	var err error
+	load.Stdin, err = save.StdoutPipe()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := load.Start(); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := save.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err := load.Wait(); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return image, nil
+}
+
 func cleanHost(ctx context.Context, logger *slog.Logger, host string) error {
 	keygen := exec.CommandContext(ctx, "ssh-keygen", "-F", host)
 	keygen.Stderr = os.Stderr
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testComposeProject() map[string]any {
+	return map[string]any{
+		"name": "foo",
+		"services": map[string]any{
+			"web": map[string]any{
+				"build": map[string]any{"context": "web"},
+			},
+			"worker": map[string]any{
+				"build": map[string]any{"context": "worker"},
+				"image": "foo/worker",
+			},
+			"redis": map[string]any{
+				"image": "redis:7",
+			},
+		},
+	}
+}
+
+func TestOverrideImagesSSH(t *testing.T) {
+	project := testComposeProject()
+	var shipped []string
+	err := overrideImages(project, shipSSH, func(service, local string) (string, error) {
+		shipped = append(shipped, local)
+		return sshImage(project, service, "v20261019.1"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"foo-web", "foo/worker"}; !reflect.DeepEqual(shipped, want) {
+		t.Errorf("unexpected shipped images: %v", shipped)
+	}
+	want := map[string]any{
+		"web": map[string]any{
+			"image":       "foo-web:v20261019.1",
+			"pull_policy": "never",
+		},
+		"worker": map[string]any{
+			"image":       "foo-worker:v20261019.1",
+			"pull_policy": "never",
+		},
+		"redis": map[string]any{
+			"image": "redis:7",
+		},
+	}
+	if !reflect.DeepEqual(project["services"], want) {
+		t.Errorf("unexpected services: %v", project["services"])
+	}
+}
+
+func TestOverrideImagesRegistry(t *testing.T) {
+	project := testComposeProject()
+	err := overrideImages(project, shipRegistry, func(service, local string) (string, error) {
+		return "eu.gcr.io/foo-project/" + service + "@sha256:0123", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"web": map[string]any{
+			"image": "eu.gcr.io/foo-project/web@sha256:0123",
+		},
+		"worker": map[string]any{
+			"image": "eu.gcr.io/foo-project/worker@sha256:0123",
+		},
+		"redis": map[string]any{
+			"image": "redis:7",
+		},
+	}
+	if !reflect.DeepEqual(project["services"], want) {
+		t.Errorf("unexpected services: %v", project["services"])
+	}
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/altipla-consulting/errors"
 	"github.com/spf13/cobra"
 
+	"github.com/altipla-consulting/wave/internal/aws"
 	"github.com/altipla-consulting/wave/internal/query"
 )
 
@@ -68,16 +69,17 @@ func init() {
 		}
 
 		logger.Info("Log in to ECR")
-		login := exec.Command("aws", "ecr", "get-login-password", "--region", flagRegion)
-		var buf bytes.Buffer
-		login.Stdout = &buf
-		login.Stderr = os.Stderr
-		if err := login.Run(); err != nil {
+		client, err := aws.NewClient(command.Context(), flagRegion)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		auth, err := client.ECRAuthorizationToken(command.Context())
+		if err != nil {
 			return errors.Trace(err)
 		}
 
-		login = exec.Command("docker", "login", "--username", "AWS", "--password-stdin", flagRepo)
-		login.Stdin = &buf
+		login := exec.Command("docker", "login", "--username", auth.Username, "--password-stdin", flagRepo)
+		login.Stdin = strings.NewReader(auth.Password)
 		login.Stdout = os.Stdout
 		login.Stderr = os.Stderr
 		if err := login.Run(); err != nil {
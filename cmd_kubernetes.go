@@ -6,22 +6,37 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/altipla-consulting/errors"
 	"github.com/atlassian/go-sentry-api"
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 
 	"github.com/altipla-consulting/wave/embed"
 	"github.com/altipla-consulting/wave/internal/env"
+	"github.com/altipla-consulting/wave/internal/envfile"
+	"github.com/altipla-consulting/wave/internal/environment"
+	"github.com/altipla-consulting/wave/internal/filter"
+	"github.com/altipla-consulting/wave/internal/gerrit"
+	"github.com/altipla-consulting/wave/internal/gitops"
+	"github.com/altipla-consulting/wave/internal/helm"
+	"github.com/altipla-consulting/wave/internal/kubeclient"
+	"github.com/altipla-consulting/wave/internal/kubectl"
 	"github.com/altipla-consulting/wave/internal/query"
+	"github.com/altipla-consulting/wave/internal/sealedsecrets"
+	"github.com/altipla-consulting/wave/internal/validate"
 )
 
 var cmdKubernetes = &cobra.Command{
@@ -32,55 +47,853 @@ var cmdKubernetes = &cobra.Command{
 }
 
 func init() {
-	var flagFilter string
+	var flagFilter, flagExclude []string
 	var flagEnv, flagIncludes []string
 	var flagApply, flagDisableSentry bool
-	cmdKubernetes.Flags().StringVarP(&flagFilter, "filter", "f", "", "Filter top level items when generating items.")
+	var flagPrune, flagPruneDryRun bool
+	var flagDiff, flagCommentDiff bool
+	var flagWait, flagUndo bool
+	var flagNative, flagStubNatives bool
+	var flagValidate bool
+	var flagTimeout time.Duration
+	var flagInventory, flagKubeVersion, flagPolicy string
+	var flagEnvironment, flagContext, flagNamespace string
+	var flagOutput, flagOutputDir string
+	var flagGitOpsRepo, flagGitOpsPath, flagGitOpsBranch string
+	var flagGitOpsAllowSecrets bool
+	cmdKubernetes.Flags().StringArrayVarP(&flagFilter, "filter", "f", nil, "Filter the generated items by jsonnet path with globs and {a,b} alternatives, by kind:Deployment or by label:app=foo. Repeat it to select more items.")
+	cmdKubernetes.Flags().StringArrayVar(&flagExclude, "exclude", nil, "Exclude the generated items that match the expression. It accepts the same expressions as --filter.")
 	cmdKubernetes.Flags().StringSliceVarP(&flagEnv, "env", "e", nil, "Set external variables.")
 	cmdKubernetes.Flags().StringSliceVarP(&flagIncludes, "include", "i", nil, "Directories to include when running the jsonnet script.")
+	cmdKubernetes.Flags().StringVar(&flagEnvironment, "environment", "", "Environment of the deployment. It exposes environments/NAME.libsonnet next to the script as std.extVar('env') and selects the kubectl context configured for it in environments/environments.yaml.")
+	cmdKubernetes.Flags().StringVar(&flagContext, "context", "", "Kubectl context of the cluster. Defaults to the context of the environment or the current one. Scripts can declare the expected cluster with a top-level 'wave.cluster' field.")
+	cmdKubernetes.Flags().StringVarP(&flagNamespace, "namespace", "n", "", "Namespace assigned to the namespaced objects that do not declare one.")
 	cmdKubernetes.Flags().BoolVar(&flagApply, "apply", false, "Apply the output to the Kubernetes cluster instead of printing it.")
 	cmdKubernetes.Flags().BoolVar(&flagDisableSentry, "disable-sentry", false, "Disable Sentry configurations allowing a quick break-glass deployment.")
+	cmdKubernetes.Flags().BoolVar(&flagPrune, "prune", false, "Apply with server-side apply and delete objects of the inventory that are no longer generated by the script. It cannot be combined with --filter or --exclude.")
+	cmdKubernetes.Flags().BoolVar(&flagPruneDryRun, "prune-dry-run", false, "List the objects of the inventory that would be deleted without changing the cluster.")
+	cmdKubernetes.Flags().BoolVar(&flagDiff, "diff", false, "Show the changes against the live state of the cluster. Combine with --apply to deploy them afterwards.")
+	cmdKubernetes.Flags().BoolVar(&flagCommentDiff, "comment-diff", false, "Send the diff as a comment to the Gerrit change when running a preview.")
+	cmdKubernetes.Flags().BoolVar(&flagWait, "wait", true, "Wait for the rollout of every applied Deployment and StatefulSet to finish.")
+	cmdKubernetes.Flags().DurationVar(&flagTimeout, "timeout", 10*time.Minute, "Maximum time to wait for all the rollouts to finish.")
+	cmdKubernetes.Flags().BoolVar(&flagUndo, "undo-on-failure", false, "Roll back the workloads whose rollout fails.")
+	cmdKubernetes.Flags().BoolVar(&flagStubNatives, "stub-natives", false, "Replace the values of Sentry, secrets and env files with deterministic placeholders to render the objects without credentials.")
+	cmdKubernetes.Flags().BoolVar(&flagNative, "native", false, "Use the built-in Kubernetes client instead of kubectl to apply, prune and wait for the rollouts. It cannot be combined with --diff or --undo-on-failure.")
+	cmdKubernetes.Flags().BoolVar(&flagValidate, "validate", false, "Check the objects against the bundled schemas without contacting the cluster before printing or deploying them.")
+	cmdKubernetes.Flags().StringVar(&flagKubeVersion, "kube-version", validate.Versions()[len(validate.Versions())-1], "Kubernetes version used to validate the objects and render the Helm charts. Only versions with bundled schemas are supported: "+strings.Join(validate.Versions(), ", ")+".")
+	cmdKubernetes.Flags().StringVar(&flagPolicy, "policy", "", "Jsonnet file with the rules every generated object must follow. Import wave/policies.jsonnet to use the default ones, or extend it to add and hide rules.")
+	cmdKubernetes.Flags().StringVarP(&flagOutput, "output", "o", outputJSON, "Format of the printed objects: json, yaml, yaml-stream or dir to write a file per object in --output-dir.")
+	cmdKubernetes.Flags().StringVar(&flagOutputDir, "output-dir", "", "Directory where --output=dir writes the objects. Files written previously by wave that are not generated anymore are removed.")
+	cmdKubernetes.Flags().StringVar(&flagGitOpsRepo, "gitops-repo", "", "Local clone of a GitOps repository where the objects are published with a commit instead of applying them.")
+	cmdKubernetes.Flags().StringVar(&flagGitOpsPath, "gitops-path", "", "Directory inside the GitOps repository that contains the objects of the script.")
+	cmdKubernetes.Flags().StringVar(&flagGitOpsBranch, "gitops-branch", "", "Branch of the GitOps repository where the commit is pushed. Defaults to the current branch of the clone.")
+	cmdKubernetes.Flags().BoolVar(&flagGitOpsAllowSecrets, "gitops-allow-secrets", false, "Publish the Secret objects to the GitOps repository even if their values are not encrypted.")
+	cmdKubernetes.Flags().StringVar(&flagInventory, "inventory", "", "Name of the inventory label applied to every generated object when pruning. Defaults to the path of the script followed by the environment and namespace, if any.")
+
+	cmdKubernetes.AddCommand(cmdKubernetesTest)
 
 	cmdKubernetes.RunE = func(command *cobra.Command, args []string) error {
+		switch flagOutput {
+		case outputJSON, outputYAML, outputYAMLStream:
+		case outputDir:
+			if flagOutputDir == "" {
+				return errors.Errorf("missing --output-dir flag to write the objects")
+			}
+		default:
+			return errors.Errorf("unknown --output format: %s", flagOutput)
+		}
+		if flagGitOpsRepo != "" {
+			if flagGitOpsPath == "" {
+				return errors.Errorf("missing --gitops-path flag to publish the objects")
+			}
+			if flagApply {
+				return errors.Errorf("cannot use --apply and --gitops-repo at the same time")
+			}
+		}
+
+		if (flagPrune || flagPruneDryRun) && (len(flagFilter) > 0 || len(flagExclude) > 0) {
+			return errors.Errorf("cannot use --prune or --prune-dry-run with --filter or --exclude, the objects left out would be deleted")
+		}
+		if flagNative && (flagDiff || flagUndo) {
+			return errors.Errorf("cannot use --native with --diff or --undo-on-failure, they need kubectl")
+		}
+		if flagStubNatives && (flagApply || flagDiff || flagPrune || flagPruneDryRun || flagGitOpsRepo != "") {
+			return errors.Errorf("cannot use --stub-natives when changing or comparing the cluster")
+		}
+
+		if err := validate.CheckVersion(flagKubeVersion); err != nil {
+			return errors.Trace(err)
+		}
+
+		secrets := new(secretValues)
 		opts := RunOptions{
 			NativeFuncs: []*jsonnet.NativeFunction{
 				nativeFuncSentry(flagDisableSentry),
-				nativeFuncEnvFile(),
-				nativeFuncSecret(),
+				nativeFuncEnvFile(secrets),
+				nativeFuncSecret(secrets),
 			},
-			Includes: flagIncludes,
-			Env:      flagEnv,
-			Filter:   flagFilter,
+			Includes:    flagIncludes,
+			Env:         flagEnv,
+			Filters:     flagFilter,
+			Excludes:    flagExclude,
+			Namespace:   flagNamespace,
+			KubeVersion: flagKubeVersion,
+		}
+		if flagStubNatives {
+			opts.NativeFuncs = stubNativeFuncs()
 		}
-		result, err := runScript(command.Context(), args[0], opts)
+		useCluster := flagApply || flagDiff || flagPrune || flagPruneDryRun
+		kubeContext := flagContext
+		if flagEnvironment != "" {
+			deployEnv, err := environment.Load(args[0], flagEnvironment)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			opts.Environment = deployEnv.Library
+			switch {
+			case deployEnv.Context != "" && kubeContext != "" && kubeContext != deployEnv.Context:
+				return errors.Errorf("refusing to use the cluster: context %q does not match the context %q of environment %q", kubeContext, deployEnv.Context, deployEnv.Name)
+			case deployEnv.Context != "":
+				kubeContext = deployEnv.Context
+			case useCluster && kubeContext == "":
+				return errors.Errorf("refusing to use the cluster: no kubectl context configured for environment %q in %s", deployEnv.Name, filepath.Join(filepath.Dir(args[0]), environment.Dir, environment.ConfigFile))
+			}
+		}
+		if kubeContext != "" && useCluster {
+			slog.Info("Use the cluster of the context", slog.String("context", kubeContext))
+			command.SetContext(kubectl.WithContext(command.Context(), kubeContext))
+		}
+		if flagPrune || flagPruneDryRun {
+			if flagInventory == "" {
+				flagInventory = inventoryName(args[0], flagEnvironment, flagNamespace)
+			}
+			opts.Labels = map[string]string{
+				inventoryLabel: flagInventory,
+			}
+		}
+		list, err := runScript(command.Context(), args[0], opts)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		if useCluster && list.cluster != "" {
+			if err := checkCluster(command.Context(), list.cluster); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		// Run every check before failing to report all the problems at once.
+		var checks []string
+		if flagValidate {
+			if err := validateList(list, flagKubeVersion); err != nil {
+				slog.Error(err.Error())
+				checks = append(checks, "validation")
+			}
+		}
+		if flagPolicy != "" {
+			if err := checkPolicy(command.Context(), flagPolicy, list, opts); err != nil {
+				slog.Error(err.Error())
+				checks = append(checks, "policy")
+			}
+		}
+		if len(checks) > 0 {
+			return errors.Errorf("the objects failed the checks: %s", strings.Join(checks, ", "))
+		}
+
+		var live cluster = kubectlCluster{}
+		if flagNative && useCluster {
+			client, err := kubeclient.New(kubectl.ContextName(command.Context()))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			live = &nativeCluster{client: client}
+		}
+
+		if flagPruneDryRun {
+			candidates, err := pruneCandidates(command.Context(), live, list, flagInventory)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(candidates) == 0 {
+				slog.Info("No objects to prune", slog.String("inventory", flagInventory))
+			}
+			for _, obj := range candidates {
+				fmt.Println(describeObject(obj))
+			}
+			return nil
+		}
+
+		result, err := list.Encode()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if flagDiff {
+			input, err := list.Encode()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			diff, err := kubectl.Diff(command.Context(), input, kubectl.ApplyOptions{ServerSide: flagPrune})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			diff = secrets.Mask(diff)
+			if diff == "" {
+				slog.Info("No changes against the live state of the cluster")
+			} else {
+				fmt.Print(colorizeDiff(diff))
+			}
+			if flagCommentDiff && gerrit.IsPreview() {
+				if err := gerrit.Comment(diffComment(args[0], diff)); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			if !flagApply {
+				return nil
+			}
+		}
+
+		if flagGitOpsRepo != "" {
+			return errors.Trace(publishGitOps(command.Context(), list, args[0], gitOpsOptions{
+				Repo:         flagGitOpsRepo,
+				Path:         flagGitOpsPath,
+				Branch:       flagGitOpsBranch,
+				AllowSecrets: flagGitOpsAllowSecrets,
+			}))
+		}
 
 		if !flagApply {
-			fmt.Println(result.String())
+			switch flagOutput {
+			case outputJSON:
+				fmt.Println(result.String())
+			case outputDir:
+				if err := list.WriteDir(flagOutputDir); err != nil {
+					return errors.Trace(err)
+				}
+			default:
+				content, err := list.EncodeYAML(flagOutput == outputYAMLStream)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				fmt.Print(string(content))
+			}
 			return nil
 		}
 
 		slog.Info("Deploy generated file", slog.String("filename", args[0]), slog.String("version", query.Version(command.Context())))
 
-		apply := exec.Command("kubectl", "apply", "-f", "-")
-		apply.Stdout = os.Stdout
-		apply.Stderr = os.Stderr
-		apply.Stdin = result
-		if err := apply.Run(); err != nil {
+		if native, ok := live.(*nativeCluster); ok {
+			var objects []map[string]any
+			for _, item := range list.Items {
+				objects = append(objects, item.(map[string]any))
+			}
+			results, err := native.client.ApplyAll(command.Context(), objects)
+			for _, result := range results {
+				fmt.Println(result)
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			if err := kubectl.Apply(command.Context(), result, kubectl.ApplyOptions{ServerSide: flagPrune}); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		if flagPrune {
+			candidates, err := pruneCandidates(command.Context(), live, list, flagInventory)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(candidates) > 0 {
+				for _, obj := range candidates {
+					slog.Info("Prune object", slog.String("object", describeObject(obj)))
+				}
+				if err := live.Delete(command.Context(), candidates); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		if flagWait {
+			if err := waitRollouts(command.Context(), live, list, flagTimeout, flagUndo); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// checkCluster verifies that the kubectl context points to the cluster declared
+// by the script in wave.cluster.
+func checkCluster(ctx context.Context, expected string) error {
+	config, err := kubeclient.LoadKubeconfig()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	name := kubectl.ContextName(ctx)
+	if name == "" {
+		name = config.CurrentContext
+	}
+	kctx, _, _, err := config.Resolve(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if kctx.Cluster != expected {
+		return errors.Errorf("refusing to use the cluster %q of context %q: the script expects cluster %q", kctx.Cluster, name, expected)
+	}
+	return nil
+}
+
+// validateList checks every object of the list and logs the problems with the
+// jsonnet path that generated the object.
+func validateList(list *k8sList, version string) error {
+	validator, err := validate.New(version)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var failed int
+	for i, item := range list.Items {
+		obj := item.(map[string]any)
+		for _, problem := range validator.Validate(obj) {
+			attrs := []any{
+				slog.String("path", list.paths[i]),
+				slog.String("object", describeObject(obj)),
+			}
+			if problem.Field != "" {
+				attrs = append(attrs, slog.String("field", problem.Field))
+			}
+			if problem.Warning {
+				slog.Warn(problem.Message, attrs...)
+				continue
+			}
+			slog.Error(problem.Message, attrs...)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d validation errors for Kubernetes %s", failed, version)
+	}
+	slog.Info("All objects are valid", slog.String("kubernetes", version))
+	return nil
+}
+
+// checkPolicy evaluates every rule of the policy file against every object of
+// the list. Rules are functions that receive the object and return true or an
+// empty list when it is valid, and false, a message or a list of messages otherwise.
+func checkPolicy(ctx context.Context, filename string, list *k8sList, opts RunOptions) error {
+	vm, err := newVM(ctx, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	objects, err := json.Marshal(list.Items)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	vm.TLACode("objects", string(objects))
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	snippet := fmt.Sprintf(`
+		function(objects)
+			local policy = import %q;
+			[
+				{ [rule]: policy[rule](object) for rule in std.objectFields(policy) }
+				for object in objects
+			]
+	`, abs)
+	output, err := vm.EvaluateAnonymousSnippet(filename, snippet)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var results []map[string]any
+	if err := json.Unmarshal([]byte(output), &results); err != nil {
+		return errors.Trace(err)
+	}
+
+	var violations int
+	for i, result := range results {
+		obj := list.Items[i].(map[string]any)
+		var rules []string
+		for rule := range result {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			for _, msg := range policyViolations(rule, result[rule]) {
+				slog.Error(msg, slog.String("rule", rule), slog.String("path", list.paths[i]), slog.String("object", describeObject(obj)))
+				violations++
+			}
+		}
+	}
+	if violations > 0 {
+		return errors.Errorf("%d policy violations in %s", violations, filename)
+	}
+	return nil
+}
+
+func policyViolations(rule string, result any) []string {
+	switch result := result.(type) {
+	case nil:
+		return nil
+	case bool:
+		if result {
+			return nil
+		}
+		return []string{"rule " + rule + " failed"}
+	case string:
+		return []string{result}
+	case []any:
+		var msgs []string
+		for _, item := range result {
+			msgs = append(msgs, fmt.Sprint(item))
+		}
+		return msgs
+	}
+	return []string{fmt.Sprintf("rule %s returned an unexpected value: %v", rule, result)}
+}
+
+// publishGitOps writes the objects to the GitOps repository and pushes a commit
+// with them if anything changed.
+type gitOpsOptions struct {
+	Repo   string
+	Path   string
+	Branch string
+
+	// AllowSecrets publishes the Secret objects too. Their values are only encoded
+	// in base64, so they are refused by default.
+	AllowSecrets bool
+}
+
+func publishGitOps(ctx context.Context, list *k8sList, filename string, opts gitOpsOptions) error {
+	repo, path, branch := opts.Repo, opts.Path, opts.Branch
+	logger := slog.With(slog.String("repo", repo), slog.String("path", path))
+	if !opts.AllowSecrets {
+		var secrets []string
+		for _, item := range list.Items {
+			obj := item.(map[string]any)
+			if obj["apiVersion"] == "v1" && obj["kind"] == "Secret" {
+				secrets = append(secrets, describeObject(obj))
+			}
+		}
+		if len(secrets) > 0 {
+			return errors.Errorf("refusing to publish plain secrets to the GitOps repository: %s. Use objects.SealedSecret of wave/v2.jsonnet instead, or pass --gitops-allow-secrets", strings.Join(secrets, ", "))
+		}
+	}
+	if branch == "" {
+		current, err := gitops.CurrentBranch(ctx, repo)
+		if err != nil {
 			return errors.Trace(err)
 		}
+		if current == "" {
+			return errors.Errorf("the GitOps repository %s is in a detached HEAD, configure the --gitops-branch flag", repo)
+		}
+		branch = current
+	}
+	if err := list.WriteDir(filepath.Join(repo, path)); err != nil {
+		return errors.Trace(err)
+	}
 
+	version := query.Version(ctx)
+	msg := fmt.Sprintf("Deploy %s to %s\n\nVersion: %s\nScript: %s\n", version, path, version, filename)
+	if gerrit.ChangeNumber() != "" {
+		msg += fmt.Sprintf("Gerrit-Change: %s,%s\n", gerrit.ChangeNumber(), gerrit.PatchSet())
+	}
+	published, err := gitops.Publish(ctx, repo, gitops.PublishOptions{
+		Path:    path,
+		Branch:  branch,
+		Message: msg,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !published {
+		logger.Info("No changes to publish")
 		return nil
 	}
+	hash, err := gitops.Head(ctx, repo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	logger.Info("Objects published", slog.String("commit", hash), slog.String("version", version))
+	return nil
+}
+
+// waitRollouts tracks every Deployment and StatefulSet of the list until their
+// rollout completes. It reports the failing pods of the first workload that fails.
+func waitRollouts(ctx context.Context, live cluster, list *k8sList, timeout time.Duration, undo bool) error {
+	deadline := time.Now().Add(timeout)
+	for _, item := range list.Items {
+		obj := item.(map[string]any)
+		kind, _ := obj["kind"].(string)
+		if kind != "Deployment" && kind != "StatefulSet" {
+			continue
+		}
+		name := kubectl.Name(obj)
+		ns := kubectl.Namespace(obj)
+
+		logger := slog.With(slog.String("kind", kind), slog.String("name", name))
+		logger.Info("Wait for rollout")
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.Errorf("timeout waiting for the rollouts to finish")
+		}
+		if err := live.RolloutStatus(ctx, kind, name, ns, remaining.Round(time.Second)); err != nil {
+			logger.Error("Rollout failed")
+			if err := printFailingPods(ctx, live, obj); err != nil {
+				return errors.Trace(err)
+			}
+			if undo {
+				logger.Warn("Roll back to the previous revision")
+				if err := live.RolloutUndo(ctx, kind, name, ns); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			return errors.Errorf("rollout of %s %s failed", kind, name)
+		}
+	}
+	return nil
+}
+
+// maxFailingPods limits the output when a lot of replicas fail at the same time.
+const maxFailingPods = 3
+
+func printFailingPods(ctx context.Context, live cluster, obj map[string]any) error {
+	spec, _ := obj["spec"].(map[string]any)
+	selector, _ := spec["selector"].(map[string]any)
+	matchLabels, _ := selector["matchLabels"].(map[string]any)
+	if len(matchLabels) == 0 {
+		return nil
+	}
+	var keys []string
+	for k := range matchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var labels []string
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf("%s=%v", k, matchLabels[k]))
+	}
+
+	ns := kubectl.Namespace(obj)
+	pods, err := live.Pods(ctx, ns, strings.Join(labels, ","))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var printed int
+	for _, pod := range pods {
+		if podReady(pod) {
+			continue
+		}
+		if printed == maxFailingPods {
+			break
+		}
+		printed++
+
+		name := kubectl.Name(pod)
+		slog.Error("Events of the failing pod", slog.String("pod", name))
+		if err := live.PrintEvents(ctx, ns, name); err != nil {
+			return errors.Trace(err)
+		}
+		slog.Error("Last logs of the failing pod", slog.String("pod", name))
+		if err := live.PrintLogs(ctx, ns, name, 20); err != nil {
+			// Containers that never started do not have logs.
+			slog.Warn("Cannot read the pod logs", slog.String("pod", name), slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+func podReady(pod map[string]any) bool {
+	status, _ := pod["status"].(map[string]any)
+	conditions, _ := status["conditions"].([]any)
+	for _, c := range conditions {
+		if c, ok := c.(map[string]any); ok && c["type"] == "Ready" {
+			return c["status"] == "True"
+		}
+	}
+	return false
+}
+
+// cluster reads and changes the live objects, shelling out to kubectl or with the
+// built-in client when using --native.
+type cluster interface {
+	CurrentNamespace(ctx context.Context) (string, error)
+	ListLabeled(ctx context.Context, selector string) ([]map[string]any, error)
+	Delete(ctx context.Context, objects []map[string]any) error
+	RolloutStatus(ctx context.Context, kind, name, namespace string, timeout time.Duration) error
+	RolloutUndo(ctx context.Context, kind, name, namespace string) error
+	Pods(ctx context.Context, namespace, selector string) ([]map[string]any, error)
+	PrintEvents(ctx context.Context, namespace, pod string) error
+	PrintLogs(ctx context.Context, namespace, pod string, lines int) error
+}
+
+type kubectlCluster struct{}
+
+func (kubectlCluster) CurrentNamespace(ctx context.Context) (string, error) {
+	return kubectl.CurrentNamespace(ctx)
+}
+
+func (kubectlCluster) ListLabeled(ctx context.Context, selector string) ([]map[string]any, error) {
+	return kubectl.ListLabeled(ctx, selector)
+}
+
+func (kubectlCluster) Delete(ctx context.Context, objects []map[string]any) error {
+	return kubectl.Delete(ctx, objects)
+}
+
+func (kubectlCluster) RolloutStatus(ctx context.Context, kind, name, namespace string, timeout time.Duration) error {
+	return kubectl.RolloutStatus(ctx, kind, name, namespace, timeout)
+}
+
+func (kubectlCluster) RolloutUndo(ctx context.Context, kind, name, namespace string) error {
+	return kubectl.RolloutUndo(ctx, kind, name, namespace)
+}
+
+func (kubectlCluster) Pods(ctx context.Context, namespace, selector string) ([]map[string]any, error) {
+	return kubectl.Pods(ctx, namespace, selector)
+}
+
+func (kubectlCluster) PrintEvents(ctx context.Context, namespace, pod string) error {
+	return kubectl.PrintEvents(ctx, namespace, pod)
+}
+
+func (kubectlCluster) PrintLogs(ctx context.Context, namespace, pod string, lines int) error {
+	return kubectl.PrintLogs(ctx, namespace, pod, lines)
+}
+
+type nativeCluster struct {
+	client *kubeclient.Client
+}
+
+func (live *nativeCluster) CurrentNamespace(ctx context.Context) (string, error) {
+	return live.client.Namespace, nil
+}
+
+func (live *nativeCluster) ListLabeled(ctx context.Context, selector string) ([]map[string]any, error) {
+	return live.client.ListLabeled(ctx, selector)
+}
+
+func (live *nativeCluster) Delete(ctx context.Context, objects []map[string]any) error {
+	for _, obj := range objects {
+		if err := live.client.Delete(ctx, obj); err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Printf("%s deleted\n", strings.ToLower(describeObject(obj)))
+	}
+	return nil
+}
+
+func (live *nativeCluster) RolloutStatus(ctx context.Context, kind, name, namespace string, timeout time.Duration) error {
+	return live.client.RolloutStatus(ctx, kind, name, namespace, timeout)
+}
+
+func (live *nativeCluster) RolloutUndo(ctx context.Context, kind, name, namespace string) error {
+	return errors.Errorf("cannot roll back %s %s without kubectl", kind, name)
+}
+
+func (live *nativeCluster) Pods(ctx context.Context, namespace, selector string) ([]map[string]any, error) {
+	if namespace == "" {
+		namespace = live.client.Namespace
+	}
+	return live.client.List(ctx, "v1", "Pod", namespace, kubeclient.ListOptions{LabelSelector: selector})
+}
+
+func (live *nativeCluster) PrintEvents(ctx context.Context, namespace, pod string) error {
+	if namespace == "" {
+		namespace = live.client.Namespace
+	}
+	events, err := live.client.List(ctx, "v1", "Event", namespace, kubeclient.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + pod,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return fmt.Sprint(events[i]["lastTimestamp"]) < fmt.Sprint(events[j]["lastTimestamp"])
+	})
+	for _, event := range events {
+		fmt.Printf("%v\t%v\t%v\t%v\n", event["lastTimestamp"], event["type"], event["reason"], event["message"])
+	}
+	return nil
+}
+
+func (live *nativeCluster) PrintLogs(ctx context.Context, namespace, pod string, lines int) error {
+	if namespace == "" {
+		namespace = live.client.Namespace
+	}
+	obj, err := live.client.Get(ctx, "v1", "Pod", namespace, pod)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	spec, _ := obj["spec"].(map[string]any)
+	containers, _ := spec["containers"].([]any)
+	for _, container := range containers {
+		container, _ := container.(map[string]any)
+		name, _ := container["name"].(string)
+		logs, err := live.client.Logs(ctx, namespace, pod, name, lines)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(logs, "\n"), "\n") {
+			fmt.Printf("[pod/%s/%s] %s\n", pod, name, line)
+		}
+	}
+	return nil
+}
+
+// inventoryLabel is applied to every generated object when pruning to find them
+// later in the cluster.
+const inventoryLabel = "wave.altipla.consulting/inventory"
+
+var reInvalidLabelChars = regexp.MustCompile(`[^a-z0-9-_.]+`)
+
+// inventoryName builds a valid label value from the path of the script and the
+// environment and namespace of the deployment, if any, so the same script
+// deployed twice in a cluster does not prune the objects of the other one.
+func inventoryName(filename, environment, namespace string) string {
+	var suffix string
+	for _, part := range []string{environment, namespace} {
+		if part != "" {
+			suffix += "." + reInvalidLabelChars.ReplaceAllString(strings.ToLower(part), "-")
+		}
+	}
+
+	name := filepath.ToSlash(filepath.Clean(filename))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = reInvalidLabelChars.ReplaceAllString(strings.ToLower(name), "-")
+	if max := 63 - len(suffix); len(name) > max {
+		name = name[len(name)-max:]
+	}
+	name += suffix
+	if len(name) > 63 {
+		name = name[len(name)-63:]
+	}
+	return strings.Trim(name, "-_.")
+}
+
+// pruneCandidates returns the objects of the inventory that live in the cluster
+// but are no longer generated by the script.
+func pruneCandidates(ctx context.Context, live cluster, list *k8sList, inventory string) ([]map[string]any, error) {
+	defaultNamespace, err := live.CurrentNamespace(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	labeled, err := live.ListLabeled(ctx, inventoryLabel+"="+inventory)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	generated := make(map[string]bool)
+	generatedCluster := make(map[string]bool)
+	for _, item := range list.Items {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		ns := kubectl.Namespace(obj)
+		if ns == "" {
+			ns = defaultNamespace
+		}
+		generated[objectKey(obj, ns)] = true
+		generatedCluster[objectKey(obj, "")] = true
+	}
+
+	var candidates []map[string]any
+	for _, obj := range labeled {
+		metadata, _ := obj["metadata"].(map[string]any)
+		if owners, _ := metadata["ownerReferences"].([]any); len(owners) > 0 {
+			continue
+		}
+		ns := kubectl.Namespace(obj)
+		if ns == "" && generatedCluster[objectKey(obj, "")] {
+			continue
+		}
+		if ns != "" && generated[objectKey(obj, ns)] {
+			continue
+		}
+		candidates = append(candidates, obj)
+	}
+	return candidates, nil
+}
+
+// maxCommentLength keeps the Gerrit comments below the size limit of the server.
+const maxCommentLength = 15000
+
+func diffComment(filename, diff string) string {
+	if diff == "" {
+		return "No Kubernetes changes in " + filename + "."
+	}
+	if len(diff) > maxCommentLength {
+		diff = diff[:maxCommentLength] + "\n[...] diff truncated, read the build logs for the full output."
+	}
+	return "Kubernetes changes in " + filename + ":\n\n" + diff
+}
+
+func colorizeDiff(diff string) string {
+	if os.Getenv("NO_COLOR") != "" {
+		return diff
+	}
+
+	const (
+		reset = "\x1b[0m"
+		bold  = "\x1b[1m"
+		red   = "\x1b[31m"
+		green = "\x1b[32m"
+		cyan  = "\x1b[36m"
+	)
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			sb.WriteString(bold + line + reset)
+		case strings.HasPrefix(line, "@@"):
+			sb.WriteString(cyan + line + reset)
+		case strings.HasPrefix(line, "+"):
+			sb.WriteString(green + line + reset)
+		case strings.HasPrefix(line, "-"):
+			sb.WriteString(red + line + reset)
+		default:
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+func objectKey(obj map[string]any, ns string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", kubectl.Group(obj), obj["kind"], ns, kubectl.Name(obj))
+}
+
+func describeObject(obj map[string]any) string {
+	if ns := kubectl.Namespace(obj); ns != "" {
+		return fmt.Sprintf("%s %s/%s", obj["kind"], ns, kubectl.Name(obj))
+	}
+	return fmt.Sprintf("%s %s", obj["kind"], kubectl.Name(obj))
 }
 
 type RunOptions struct {
 	NativeFuncs []*jsonnet.NativeFunction
 	Includes    []string
 	Env         []string
-	Filter      string
+	Filters     []string
+	Excludes    []string
+
+	// Environment is the library exposed to the script as std.extVar('env').
+	Environment string
+
+	// Version replaces the version of the build in the external variables.
+	Version string
+
+	// Labels are added to the metadata of every generated object.
+	Labels map[string]string
+
+	// Namespace is assigned to the namespaced objects that do not have one.
+	Namespace string
+
+	// KubeVersion is reported to the Helm charts. Defaults to the newest version
+	// with bundled schemas.
+	KubeVersion string
 }
 
 type customImporter struct {
@@ -89,13 +902,13 @@ type customImporter struct {
 }
 
 func (c *customImporter) Import(importedFrom string, importedPath string) (contents jsonnet.Contents, foundAt string, err error) {
-	if importedPath == "wave.jsonnet" {
+	if _, ok := c.mem.Data[importedPath]; ok {
 		return c.mem.Import(importedFrom, importedPath)
 	}
 	return c.file.Import(importedFrom, importedPath)
 }
 
-func runScript(ctx context.Context, filename string, opts RunOptions) (*bytes.Buffer, error) {
+func newVM(ctx context.Context, opts RunOptions) (*jsonnet.VM, error) {
 	vm := jsonnet.MakeVM()
 	vm.Importer(&customImporter{
 		file: &jsonnet.FileImporter{
@@ -103,15 +916,34 @@ func runScript(ctx context.Context, filename string, opts RunOptions) (*bytes.Bu
 		},
 		mem: &jsonnet.MemoryImporter{
 			Data: map[string]jsonnet.Contents{
-				"wave.jsonnet": jsonnet.MakeContentsRaw(embed.Wave),
+				"wave.jsonnet":          jsonnet.MakeContentsRaw(embed.WaveV1),
+				"wave/v1.jsonnet":       jsonnet.MakeContentsRaw(embed.WaveV1),
+				"wave/v2.jsonnet":       jsonnet.MakeContentsRaw(embed.WaveV2),
+				"wave/policies.jsonnet": jsonnet.MakeContentsRaw(embed.Policies),
 			},
 		},
 	})
+	vm.NativeFunction(nativeFuncDeprecated())
+	vm.NativeFunction(nativeFuncHelmTemplate(opts))
+	vm.NativeFunction(nativeFuncSealSecret())
 	for _, f := range opts.NativeFuncs {
 		vm.NativeFunction(f)
 	}
-	vm.ExtVar("version", query.Version(ctx))
-	vm.ExtVar("image-tag", query.VersionImageTag(ctx))
+	if opts.Version != "" {
+		vm.ExtVar("version", opts.Version)
+		vm.ExtVar("image-tag", opts.Version)
+	} else {
+		vm.ExtVar("version", query.Version(ctx))
+		vm.ExtVar("image-tag", query.VersionImageTag(ctx))
+	}
+
+	if opts.Environment != "" {
+		abs, err := filepath.Abs(opts.Environment)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		vm.ExtCode("env", fmt.Sprintf("import %q", abs))
+	}
 
 	for _, v := range opts.Env {
 		parts := strings.Split(v, "=")
@@ -120,7 +952,18 @@ func runScript(ctx context.Context, filename string, opts RunOptions) (*bytes.Bu
 		}
 		vm.ExtVar(parts[0], parts[1])
 	}
+	return vm, nil
+}
 
+// clusterKey is the top-level field of the script that declares the name of the
+// expected cluster. The dot prevents collisions with the fields of existing scripts.
+const clusterKey = "wave.cluster"
+
+func runScript(ctx context.Context, filename string, opts RunOptions) (*k8sList, error) {
+	vm, err := newVM(ctx, opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	output, err := vm.EvaluateFile(filename)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -133,18 +976,57 @@ func runScript(ctx context.Context, filename string, opts RunOptions) (*bytes.Bu
 		APIVersion: "v1",
 		Kind:       "List",
 	}
-	var filters []string
-	if opts.Filter != "" {
-		filters = strings.Split(opts.Filter, ".")
+	if root, ok := result.(map[string]any); ok && root["apiVersion"] == nil && root[clusterKey] != nil {
+		cluster, ok := root[clusterKey].(string)
+		if !ok || cluster == "" {
+			return nil, errors.Errorf("unexpected value in %s: expected the name of the cluster", clusterKey)
+		}
+		list.cluster = cluster
+		delete(root, clusterKey)
 	}
-	extractItems(list, filters, result)
-
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(list); err != nil {
+	filters, err := filter.NewSet(opts.Filters, opts.Excludes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := extractItems(list, filters, nil, result); err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	return &buf, nil
+	if len(opts.Labels) > 0 {
+		for _, item := range list.Items {
+			obj := item.(map[string]any)
+			metadata, ok := obj["metadata"].(map[string]any)
+			if !ok {
+				metadata = make(map[string]any)
+				obj["metadata"] = metadata
+			}
+			labels, ok := metadata["labels"].(map[string]any)
+			if !ok {
+				labels = make(map[string]any)
+				metadata["labels"] = labels
+			}
+			for k, v := range opts.Labels {
+				labels[k] = v
+			}
+		}
+	}
+
+	if opts.Namespace != "" {
+		for _, item := range list.Items {
+			obj := item.(map[string]any)
+			if kubectl.ClusterScoped(obj) || kubectl.Namespace(obj) != "" {
+				continue
+			}
+			metadata, ok := obj["metadata"].(map[string]any)
+			if !ok {
+				metadata = make(map[string]any)
+				obj["metadata"] = metadata
+			}
+			metadata["namespace"] = opts.Namespace
+		}
+	}
+
+	return list, nil
 }
 
 func nativeFuncSentry(disableSentry bool) *jsonnet.NativeFunction {
@@ -179,25 +1061,227 @@ func nativeFuncSentry(disableSentry bool) *jsonnet.NativeFunction {
 	}
 }
 
-func nativeFuncEnvFile() *jsonnet.NativeFunction {
+// stubNativeFuncs replaces the native functions that need credentials with
+// deterministic placeholders.
+func stubNativeFuncs() []*jsonnet.NativeFunction {
+	return []*jsonnet.NativeFunction{
+		{
+			Name:   "sentry",
+			Params: []ast.Identifier{"name"},
+			Func: func(args []any) (any, error) {
+				return fmt.Sprintf("<sentry:%s>", args[0]), nil
+			},
+		},
+		{
+			Name:   "envfile",
+			Params: []ast.Identifier{"filename"},
+			Func: func(args []any) (any, error) {
+				filename := args[0].(string)
+				// The keys are read without decrypting the values. A missing file would
+				// silently render an empty secret.
+				keys, err := envfile.Keys(filename)
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						return nil, errors.Errorf("envfile: missing env file %s", filename)
+					}
+					return nil, errors.Trace(err)
+				}
+				res := make(map[string]any)
+				for _, k := range keys {
+					res[k] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("<envfile:%s:%s>", filename, k)))
+				}
+				return res, nil
+			},
+		},
+		{
+			Name:   "secret",
+			Params: []ast.Identifier{"name"},
+			Func: func(args []any) (any, error) {
+				return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("<secret:%s>", args[0]))), nil
+			},
+		},
+		{
+			Name:   "sealSecret",
+			Params: []ast.Identifier{"certificate", "scope", "namespace", "name", "data"},
+			Func: func(args []any) (any, error) {
+				data, _ := args[4].(map[string]any)
+				res := make(map[string]any)
+				for k := range data {
+					res[k] = fmt.Sprintf("<sealed:%s:%s>", args[3], k)
+				}
+				return res, nil
+			},
+		},
+	}
+}
+
+// nativeFuncDeprecated warns once about every deprecated helper of the library
+// used by the script.
+func nativeFuncDeprecated() *jsonnet.NativeFunction {
+	warned := make(map[string]bool)
+	return &jsonnet.NativeFunction{
+		Name:   "deprecated",
+		Params: []ast.Identifier{"name", "message"},
+		Func: func(args []any) (any, error) {
+			name := args[0].(string)
+			if !warned[name] {
+				warned[name] = true
+				slog.Warn("Deprecated helper of the wave library", slog.String("helper", name), slog.String("message", args[1].(string)))
+			}
+			return true, nil
+		},
+	}
+}
+
+// nativeFuncHelmTemplate renders a local chart. Objects are returned keyed by
+// their kind and name so they can be filtered like any other object of the script.
+// The release is installed in the namespace of the command if the script does not
+// choose one.
+//
+// Charts are rendered with helm.Template, not with the Helm engine. Packaged .tgz
+// dependencies, .Files.Glob, .Files.AsConfig, .Files.AsSecrets,
+// .Capabilities.HelmVersion, lookup and values.schema.json are not supported, and
+// .Files.Get cannot read outside the chart directory.
+func nativeFuncHelmTemplate(runOpts RunOptions) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "helmTemplate",
+		Params: []ast.Identifier{"chart", "values", "namespace", "releaseName"},
+		Func: func(args []any) (any, error) {
+			chart, ok := args[0].(string)
+			if !ok {
+				return nil, errors.Errorf("helmTemplate: chart should be a string")
+			}
+			var values map[string]any
+			if args[1] != nil {
+				values, ok = args[1].(map[string]any)
+				if !ok {
+					return nil, errors.Errorf("helmTemplate: values should be an object")
+				}
+			}
+			opts := helm.Options{
+				Namespace:   runOpts.Namespace,
+				KubeVersion: runOpts.KubeVersion,
+			}
+			if args[2] != nil {
+				opts.Namespace, ok = args[2].(string)
+				if !ok {
+					return nil, errors.Errorf("helmTemplate: namespace should be a string")
+				}
+			}
+			if args[3] != nil {
+				opts.ReleaseName, ok = args[3].(string)
+				if !ok {
+					return nil, errors.Errorf("helmTemplate: releaseName should be a string")
+				}
+			}
+			if opts.KubeVersion == "" {
+				versions := validate.Versions()
+				opts.KubeVersion = versions[len(versions)-1]
+			}
+			var err error
+			opts.APIVersions, err = validate.APIVersions(opts.KubeVersion)
+			if err != nil {
+				return nil, errors.Errorf("helmTemplate: %s", err)
+			}
+			objects, err := helm.Template(chart, values, opts)
+			if err != nil {
+				return nil, errors.Errorf("helmTemplate: cannot render %s: %s", chart, err)
+			}
+			res := make(map[string]any)
+			for _, obj := range objects {
+				kind, _ := obj["kind"].(string)
+				metadata, _ := obj["metadata"].(map[string]any)
+				name, _ := metadata["name"].(string)
+				key := strings.ToLower(kind + "-" + name)
+				if _, ok := res[key]; ok {
+					return nil, errors.Errorf("helmTemplate: chart %s renders %s %s twice", chart, kind, name)
+				}
+				res[key] = obj
+			}
+			return res, nil
+		},
+	}
+}
+
+// nativeFuncSealSecret encrypts the base64 values of a secret with the public
+// certificate of the Sealed Secrets controller.
+func nativeFuncSealSecret() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "sealSecret",
+		Params: []ast.Identifier{"certificate", "scope", "namespace", "name", "data"},
+		Func: func(args []any) (any, error) {
+			certificate, ok := args[0].(string)
+			if !ok {
+				return nil, errors.Errorf("sealSecret: certificate should be a string")
+			}
+			scope, ok := args[1].(string)
+			if !ok {
+				return nil, errors.Errorf("sealSecret: scope should be a string")
+			}
+			var namespace string
+			if args[2] != nil {
+				namespace, ok = args[2].(string)
+				if !ok {
+					return nil, errors.Errorf("sealSecret: namespace should be a string")
+				}
+			}
+			name, ok := args[3].(string)
+			if !ok {
+				return nil, errors.Errorf("sealSecret: name should be a string")
+			}
+			values, ok := args[4].(map[string]any)
+			if !ok {
+				return nil, errors.Errorf("sealSecret: data should be an object")
+			}
+			data := make(map[string]string)
+			for k, v := range values {
+				s, ok := v.(string)
+				if !ok {
+					return nil, errors.Errorf("sealSecret: value %s of secret %s should be a string, got %T", k, name, v)
+				}
+				decoded, err := base64.StdEncoding.DecodeString(s)
+				if err != nil {
+					return nil, errors.Errorf("sealSecret: value %s of secret %s should be encoded in base64: %s", k, name, err)
+				}
+				data[k] = string(decoded)
+			}
+
+			key, err := sealedsecrets.ReadCertificate(certificate)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			sealed, err := sealedsecrets.Seal(key, sealedsecrets.Scope(scope), namespace, name, data)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			res := make(map[string]any)
+			for k, v := range sealed {
+				res[k] = v
+			}
+			return res, nil
+		},
+	}
+}
+
+func nativeFuncEnvFile(secrets *secretValues) *jsonnet.NativeFunction {
 	return &jsonnet.NativeFunction{
 		Name:   "envfile",
 		Params: []ast.Identifier{"filename"},
 		Func: func(args []any) (any, error) {
-			m, err := godotenv.Read(args[0].(string))
+			m, err := envfile.Read(args[0].(string))
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			res := make(map[string]any)
 			for k, v := range m {
-				res[k] = base64.StdEncoding.EncodeToString([]byte(v))
+				res[k] = secrets.Add(base64.StdEncoding.EncodeToString([]byte(v)))
 			}
 			return res, nil
 		},
 	}
 }
 
-func nativeFuncSecret() *jsonnet.NativeFunction {
+func nativeFuncSecret(secrets *secretValues) *jsonnet.NativeFunction {
 	return &jsonnet.NativeFunction{
 		Name:   "secret",
 		Params: []ast.Identifier{"name"},
@@ -206,48 +1290,224 @@ func nativeFuncSecret() *jsonnet.NativeFunction {
 			if v == "" {
 				return nil, errors.Errorf("missing environment variable %q", args[0])
 			}
-			return base64.StdEncoding.EncodeToString([]byte(v)), nil
+			return secrets.Add(base64.StdEncoding.EncodeToString([]byte(v))), nil
 		},
 	}
 }
 
+// secretValues records the values returned by the native functions that read
+// secrets to hide them from any output.
+type secretValues struct {
+	values []string
+}
+
+func (secrets *secretValues) Add(value string) string {
+	if value != "" {
+		secrets.values = append(secrets.values, value)
+	}
+	return value
+}
+
+func (secrets *secretValues) Mask(s string) string {
+	values := slices.Clone(secrets.values)
+	// Replace longer values first in case some secret contains another one.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	var oldnew []string
+	for _, value := range values {
+		oldnew = append(oldnew, value, "***")
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
 type k8sList struct {
 	APIVersion string `json:"apiVersion"`
 	Kind       string `json:"kind"`
 	Items      []any  `json:"items"`
+
+	// paths contains the jsonnet path that generated each item.
+	paths []string
+
+	// cluster is the name of the cluster declared by the script in wave.cluster.
+	cluster string
+}
+
+// Encode returns the list as a JSON document ready to send to kubectl.
+func (list *k8sList) Encode() (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &buf, nil
 }
 
-func extractItems(list *k8sList, filter []string, v any) {
+const (
+	outputJSON       = "json"
+	outputYAML       = "yaml"
+	outputYAMLStream = "yaml-stream"
+	outputDir        = "dir"
+)
+
+// EncodeYAML returns the list as a single YAML document, or as a stream of documents
+// with an object each.
+func (list *k8sList) EncodeYAML(stream bool) ([]byte, error) {
+	if !stream {
+		content, err := yaml.Marshal(list)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return content, nil
+	}
+
+	var buf bytes.Buffer
+	for i, item := range list.Items {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		content, err := yaml.Marshal(item)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		buf.Write(content)
+	}
+	return buf.Bytes(), nil
+}
+
+// manifestFile lists the files written by WriteDir in the directory. Only those
+// files are removed when the objects are no longer generated.
+const manifestFile = ".wave-manifest"
+
+// WriteDir writes a file per object named <kind>-<name>.yaml to the directory. Files
+// written previously that are not generated anymore are removed to delete their
+// objects. It refuses to write in a non-empty directory not managed by wave.
+func (list *k8sList) WriteDir(dir string) error {
+	files := make(map[string][]byte)
+	for i, item := range list.Items {
+		obj := item.(map[string]any)
+		name := strings.ToLower(fmt.Sprintf("%s-%s.yaml", obj["kind"], kubectl.Name(obj)))
+		if _, ok := files[name]; ok {
+			return errors.Errorf("duplicated file %s generated by %s", name, list.paths[i])
+		}
+		content, err := yaml.Marshal(obj)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		files[name] = content
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	previous, err := readManifest(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range previous {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	}
+
+	var names []string
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return errors.Trace(err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var manifest strings.Builder
+	for _, name := range names {
+		fmt.Fprintln(&manifest, name)
+	}
+	return errors.Trace(os.WriteFile(filepath.Join(dir, manifestFile), []byte(manifest.String()), 0644))
+}
+
+// readManifest returns the files written previously by WriteDir in the directory.
+func readManifest(dir string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, errors.Trace(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, entry := range entries {
+			// Hidden files like .gitkeep do not interfere with the objects.
+			if !strings.HasPrefix(entry.Name(), ".") {
+				return nil, errors.Errorf("the directory %s is not empty and was not written by wave, remove its files or choose another directory", dir)
+			}
+		}
+		return nil, nil
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line != filepath.Base(line) || strings.HasPrefix(line, ".") {
+			return nil, errors.Errorf("unexpected file %q in %s", line, filepath.Join(dir, manifestFile))
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// extractItems walks the output of the script collecting the Kubernetes objects
+// in a deterministic order.
+func extractItems(list *k8sList, filters *filter.Set, path []string, v any) error {
+	if !filters.Descend(path) {
+		return nil
+	}
+
 	switch v := v.(type) {
 	case map[string]any:
+		if _, ok := v["apiVersion"]; ok {
+			if filters.Match(path, v) {
+				list.Items = append(list.Items, v)
+				list.paths = append(list.paths, strings.Join(path, "."))
+			}
+			return nil
+		}
+
 		var keys []string
 		for k := range v {
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
 		for _, key := range keys {
-			child := v[key]
-			if ent, ok := child.(map[string]any); ok {
-				if _, ok := ent["apiVersion"]; ok {
-					if len(filter) == 0 || filter[0] == key {
-						list.Items = append(list.Items, ent)
-					}
-					continue
-				}
+			if err := extractItems(list, filters, append(slices.Clip(path), key), v[key]); err != nil {
+				return errors.Trace(err)
 			}
+		}
 
-			switch {
-			case len(filter) == 0:
-				extractItems(list, filter, child)
-			case filter[0] == key:
-				extractItems(list, filter[1:], child)
+	case []any:
+		for i, child := range v {
+			if err := extractItems(list, filters, append(slices.Clip(path), strconv.Itoa(i)), child); err != nil {
+				return errors.Trace(err)
 			}
 		}
 
 	case nil:
-		return
+		return nil
 
 	default:
-		panic(fmt.Sprintf("should not reach here: %#v", v))
+		return errors.Errorf("unexpected value in %s: expected Kubernetes objects but got %v", describePath(path), v)
+	}
+	return nil
+}
+
+func describePath(path []string) string {
+	if len(path) == 0 {
+		return "the root of the script"
 	}
+	return strings.Join(path, ".")
 }
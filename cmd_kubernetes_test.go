@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/altipla-consulting/wave/internal/kubectl"
+)
+
+func testConfigMaps(names ...string) *k8sList {
+	list := new(k8sList)
+	for _, name := range names {
+		list.Items = append(list.Items, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]any{"name": name},
+		})
+		list.paths = append(list.paths, name)
+	}
+	return list
+}
+
+func TestWriteDirRemovesOnlyOwnFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := testConfigMaps("foo", "bar").WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testConfigMaps("foo").WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exists := range map[string]bool{
+		"configmap-foo.yaml": true,
+		"configmap-bar.yaml": false,
+		"kustomization.yaml": true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists && err != nil {
+			t.Errorf("%s should exist: %s", name, err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", name)
+		}
+	}
+}
+
+func TestWriteDirRefusesUnmanagedDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testConfigMaps("foo").WriteDir(dir); err == nil {
+		t.Errorf("unmanaged directory should be refused")
+	}
+}
+
+func TestWriteDirAllowsHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".gitkeep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testConfigMaps("foo").WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stubNativeFunc(t *testing.T, name string) func(args []any) (any, error) {
+	t.Helper()
+	for _, f := range stubNativeFuncs() {
+		if f.Name == name {
+			return f.Func
+		}
+	}
+	t.Fatalf("missing stub native function %s", name)
+	return nil
+}
+
+func TestStubEnvFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prod.env")
+	if err := os.WriteFile(filename, []byte("FOO=secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := stubNativeFunc(t, "envfile")([]any{filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := result.(map[string]any)
+	want := base64.StdEncoding.EncodeToString([]byte("<envfile:" + filename + ":FOO>"))
+	if len(values) != 1 || values["FOO"] != want {
+		t.Errorf("unexpected placeholders: %v", values)
+	}
+}
+
+func TestStubEnvFileMissing(t *testing.T) {
+	if _, err := stubNativeFunc(t, "envfile")([]any{filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Errorf("missing env file should fail")
+	}
+}
+
+func TestSealSecretValueType(t *testing.T) {
+	_, err := nativeFuncSealSecret().Func([]any{"cert.pem", "strict", "prod", "db", map[string]any{"port": 5432.0}})
+	if err == nil || !strings.Contains(err.Error(), "value port of secret db") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunScriptCluster(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "script.jsonnet")
+	script := `{
+  'wave.cluster': 'foo-cluster',
+  wave: {
+    apiVersion: 'v1',
+    kind: 'ConfigMap',
+    metadata: { name: 'wave' },
+  },
+}`
+	if err := os.WriteFile(filename, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := runScript(context.Background(), filename, RunOptions{NativeFuncs: stubNativeFuncs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.cluster != "foo-cluster" {
+		t.Errorf("unexpected cluster: %q", list.cluster)
+	}
+	if len(list.Items) != 1 || kubectl.Name(list.Items[0].(map[string]any)) != "wave" {
+		t.Errorf("unexpected items: %v", list.Items)
+	}
+}
+
+func TestInventoryName(t *testing.T) {
+	tests := []struct {
+		filename, environment, namespace string
+		expected                         string
+	}{
+		{"k8s/deploy.jsonnet", "", "", "k8s-deploy"},
+		{"k8s/deploy.jsonnet", "staging", "", "k8s-deploy.staging"},
+		{"k8s/deploy.jsonnet", "", "foo", "k8s-deploy.foo"},
+		{"k8s/deploy.jsonnet", "Staging", "foo", "k8s-deploy.staging.foo"},
+		{strings.Repeat("a/", 40) + "deploy.jsonnet", "production", "foo", strings.Repeat("a-", 21) + "deploy.production.foo"},
+	}
+	for _, test := range tests {
+		if name := inventoryName(test.filename, test.environment, test.namespace); name != test.expected {
+			t.Errorf("inventoryName(%q, %q, %q) = %q, expected %q", test.filename, test.environment, test.namespace, name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/altipla-consulting/wave/internal/environment"
+)
+
+var cmdKubernetesTest = &cobra.Command{
+	Use:     "test",
+	Short:   "Compare the output of jsonnet scripts against their golden files.",
+	Example: "wave kubernetes test k8s/deploy.jsonnet",
+	Args:    cobra.MinimumNArgs(1),
+}
+
+// goldenVersion replaces the version of the build to keep the golden files stable.
+const goldenVersion = "golden-version"
+
+func init() {
+	var flagEnv, flagIncludes []string
+	var flagEnvironment string
+	var flagUpdate bool
+	cmdKubernetesTest.Flags().StringSliceVarP(&flagEnv, "env", "e", nil, "Set external variables.")
+	cmdKubernetesTest.Flags().StringSliceVarP(&flagIncludes, "include", "i", nil, "Directories to include when running the jsonnet script.")
+	cmdKubernetesTest.Flags().StringVar(&flagEnvironment, "environment", "", "Environment of the deployment. Every environment has its own golden files.")
+	cmdKubernetesTest.Flags().BoolVar(&flagUpdate, "update", false, "Regenerate the golden files with the current output of the scripts.")
+
+	cmdKubernetesTest.RunE = func(command *cobra.Command, args []string) error {
+		var failed int
+		for _, filename := range args {
+			logger := slog.With(slog.String("filename", filename))
+
+			opts := RunOptions{
+				NativeFuncs: stubNativeFuncs(),
+				Includes:    flagIncludes,
+				Env:         flagEnv,
+				Version:     goldenVersion,
+			}
+			if flagEnvironment != "" {
+				deployEnv, err := environment.Load(filename, flagEnvironment)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				opts.Environment = deployEnv.Library
+			}
+			list, err := runScript(command.Context(), filename, opts)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			output, err := list.EncodeYAML(true)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			golden := goldenFilename(filename, flagEnvironment)
+			if flagUpdate {
+				if err := os.WriteFile(golden, output, 0644); err != nil {
+					return errors.Trace(err)
+				}
+				logger.Info("Golden file updated", slog.String("golden", golden))
+				continue
+			}
+
+			if _, err := os.Stat(golden); os.IsNotExist(err) {
+				logger.Error("Missing golden file, run the command with --update to create it", slog.String("golden", golden))
+				failed++
+				continue
+			}
+			diff, err := goldenDiff(golden, output)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if diff != "" {
+				logger.Error("Output does not match the golden file", slog.String("golden", golden))
+				fmt.Print(colorizeDiff(diff))
+				failed++
+				continue
+			}
+			logger.Info("Output matches the golden file", slog.String("golden", golden))
+		}
+		if failed > 0 {
+			return errors.Errorf("%d scripts do not match their golden files, run the command with --update to accept the changes", failed)
+		}
+		return nil
+	}
+}
+
+// goldenFilename returns the file next to the script where the expected output is
+// stored, for example k8s/deploy.golden.yaml for k8s/deploy.jsonnet or
+// k8s/deploy.staging.golden.yaml in the staging environment.
+func goldenFilename(filename, environment string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if environment != "" {
+		base += "." + environment
+	}
+	return base + ".golden.yaml"
+}
+
+func goldenDiff(golden string, output []byte) (string, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command("diff", "-u", "--label", golden, "--label", "output", golden, "-")
+	cmd.Stdin = bytes.NewReader(output)
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// Exit code 1 means there are differences.
+		if exit := new(exec.ExitError); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+			return "", errors.Trace(err)
+		}
+	}
+	return buf.String(), nil
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/altipla-consulting/errors"
 	"github.com/atlassian/go-sentry-api"
 	"github.com/spf13/cobra"
 
+	"github.com/altipla-consulting/wave/internal/aws"
 	"github.com/altipla-consulting/wave/internal/env"
 	"github.com/altipla-consulting/wave/internal/query"
 )
@@ -28,10 +34,15 @@ type containerConfig struct {
 
 func init() {
 	var flagRepo, flagFile, flagRegion string
-	cmdLightsail.Flags().StringVar(&flagRepo, "repo", "", "ECR repository name where the container is stored.")
+	var flagWait bool
+	var flagTimeout time.Duration
+	cmdLightsail.Flags().StringVar(&flagRepo, "repo", "", "ECR repository name where the container is stored. Not needed for images pushed with wave lightsail build.")
 	cmdLightsail.Flags().StringVar(&flagFile, "file", "containers.prod.json", "Path to the JSON file to deploy.")
 	cmdLightsail.Flags().StringVar(&flagRegion, "region", "eu-west-1", "AWS region where the container is stored.")
-	cmdLightsail.MarkFlagRequired("repo")
+	cmdLightsail.Flags().BoolVar(&flagWait, "wait", true, "Wait for the deployment to be active before returning.")
+	cmdLightsail.Flags().DurationVar(&flagTimeout, "timeout", 20*time.Minute, "Maximum time to wait for the deployment to be active.")
+
+	cmdLightsail.AddCommand(cmdLightsailBuild)
 
 	cmdLightsail.RunE = func(cmd *cobra.Command, args []string) error {
 		content, err := os.ReadFile(flagFile)
@@ -53,6 +64,10 @@ func init() {
 				return query.Version(cmd.Context())
 
 			case placeholder == "REPO":
+				if flagRepo == "" {
+					mapErr = errors.Errorf("missing --repo flag")
+					return "[ERROR]"
+				}
 				return flagRepo
 
 			case strings.HasPrefix(placeholder, "SENTRY_DSN("):
@@ -82,30 +97,128 @@ func init() {
 			return errors.Trace(mapErr)
 		}
 
-		tmpFile, err := os.CreateTemp("", "*.containers.prod.json")
+		client, err := aws.NewClient(cmd.Context(), flagRegion)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		if _, err := tmpFile.Write(content); err != nil {
+		service, err := client.CreateContainerServiceDeployment(cmd.Context(), content)
+		if err != nil {
 			return errors.Trace(err)
 		}
-		logger.Info("Using deployment file", slog.String("file", tmpFile.Name()))
-
-		create := []string{
-			"aws", "lightsail",
-			"create-container-service-deployment",
-			"--service-name", config.ServiceName,
-			"--cli-input-json", "file://" + tmpFile.Name(),
-			"--region", flagRegion,
-			"--no-cli-pager",
+		if service == nil || service.NextDeployment == nil {
+			return errors.Errorf("lightsail did not return the new deployment of %s", config.ServiceName)
 		}
-		createCmd := exec.CommandContext(cmd.Context(), create[0], create[1:]...)
-		createCmd.Stdout = os.Stdout
-		createCmd.Stderr = os.Stderr
-		if err := createCmd.Run(); err != nil {
-			return errors.Trace(err)
+		version := service.NextDeployment.Version
+
+		if !flagWait {
+			return nil
+		}
+
+		logger.Info("Wait for the deployment to finish", slog.Int("deployment", version))
+		ctx, cancel := context.WithTimeout(cmd.Context(), flagTimeout)
+		defer cancel()
+		for {
+			deployment, err := lightsailDeployment(ctx, client, config.ServiceName, version)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			slog.Debug("Deployment status", slog.String("state", deployment.State))
+
+			switch deployment.State {
+			case "ACTIVATING":
+				fmt.Print(".")
+				os.Stdout.Sync()
+			case "ACTIVE":
+				fmt.Println()
+				logger.Info("Deployment completed successfully!")
+				return nil
+			case "FAILED":
+				fmt.Println()
+				reason, err := lightsailStateDetail(cmd.Context(), client, config.ServiceName)
+				if err != nil {
+					logger.Warn("Cannot read the reason of the failure", slog.String("error", err.Error()))
+					reason = "cannot read the reason of the failure, read the Lightsail console for more information"
+				}
+				logger.Error("Lightsail deployment failed", slog.Int("deployment", version), slog.String("reason", reason))
+
+				var names []string
+				for name := range deployment.Containers {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range failingContainers(reason, names) {
+					if err := printLightsailLogs(cmd.Context(), logger, client, config.ServiceName, name); err != nil {
+						logger.Warn("Cannot read the logs of the container", slog.String("container", name), slog.String("error", err.Error()))
+					}
+				}
+				return errors.Errorf("Lightsail deployment %d failed: %s", version, reason)
+			default:
+				fmt.Println()
+				return errors.Errorf("unexpected state %q of the Lightsail deployment %d", deployment.State, version)
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Println()
+				return errors.Errorf("timeout waiting for the Lightsail deployment %d to finish", version)
+			case <-time.After(5 * time.Second):
+			}
 		}
+	}
+}
+
+func lightsailDeployment(ctx context.Context, client *aws.Client, serviceName string, version int) (*aws.ContainerServiceDeployment, error) {
+	deployments, err := client.GetContainerServiceDeployments(ctx, serviceName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, deployment := range deployments {
+		if deployment.Version == version {
+			return deployment, nil
+		}
+	}
+	return nil, errors.Errorf("cannot find deployment %d of %s", version, serviceName)
+}
+
+func lightsailStateDetail(ctx context.Context, client *aws.Client, serviceName string) (string, error) {
+	services, err := client.GetContainerServices(ctx, serviceName)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(services) == 0 || services[0].StateDetail == nil {
+		return "no details available, read the Lightsail console for more information", nil
+	}
+	detail := services[0].StateDetail
+	return fmt.Sprintf("%s: %s", detail.Code, detail.Message), nil
+}
+
+var reWord = regexp.MustCompile(`[A-Za-z0-9-]+`)
+
+// failingContainers returns the containers named in the reason of the failure,
+// or all of them if the reason does not name any container.
+func failingContainers(reason string, names []string) []string {
+	words := reWord.FindAllString(reason, -1)
+	var failing []string
+	for _, name := range names {
+		if slices.Contains(words, name) {
+			failing = append(failing, name)
+		}
+	}
+	if len(failing) == 0 {
+		return names
+	}
+	return failing
+}
+
+func printLightsailLogs(ctx context.Context, logger *slog.Logger, client *aws.Client, serviceName, container string) error {
+	events, err := client.GetContainerLog(ctx, serviceName, container)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
-		return nil
+	logger.Error("Logs of the failing container", slog.String("container", container))
+	for _, event := range events {
+		fmt.Println(event.Message)
 	}
+	return nil
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFailingContainers(t *testing.T) {
+	names := []string{"api", "web", "web-worker"}
+	tests := []struct {
+		reason   string
+		expected []string
+	}{
+		{"UNKNOWN_ERROR: The container web-worker exited with code 1", []string{"web-worker"}},
+		{"ACTIVATING_DEPLOYMENT: Your container \"web\" failed the health check", []string{"web"}},
+		{"UNKNOWN_ERROR: Containers api and web took too long to start", []string{"api", "web"}},
+		{"UNKNOWN_ERROR: The deployment failed", names},
+	}
+	for _, test := range tests {
+		if got := failingContainers(test.reason, names); !slices.Equal(got, test.expected) {
+			t.Errorf("failingContainers(%q) = %v, expected %v", test.reason, got, test.expected)
+		}
+	}
+}
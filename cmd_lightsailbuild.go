@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"github.com/altipla-consulting/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/altipla-consulting/wave/internal/query"
+)
+
+var cmdLightsailBuild = &cobra.Command{
+	Use:     "build",
+	Short:   "Build a container from a predefined folder structure and push it to Lightsail Containers.",
+	Example: "wave lightsail build foo",
+	Args:    cobra.ExactArgs(1),
+}
+
+var reLightsailImage = regexp.MustCompile(`Refer to this image as "(:[^"]+)" in deployments`)
+
+func init() {
+	var flagSource, flagFile, flagRegion string
+	cmdLightsailBuild.Flags().StringVar(&flagSource, "source", "", "Source folder. Defaults to a folder with the name of the app.")
+	cmdLightsailBuild.Flags().StringVar(&flagFile, "file", "containers.prod.json", "Path to the JSON file where the pushed image will be configured.")
+	cmdLightsailBuild.Flags().StringVar(&flagRegion, "region", "eu-west-1", "AWS region where the container will be stored.")
+
+	cmdLightsailBuild.RunE = func(cmd *cobra.Command, args []string) error {
+		app := args[0]
+
+		info, err := os.Stat(flagFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		content, err := os.ReadFile(flagFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var config map[string]any
+		if err := json.Unmarshal(content, &config); err != nil {
+			return errors.Trace(err)
+		}
+		serviceName, _ := config["serviceName"].(string)
+		if serviceName == "" {
+			return errors.Errorf("missing serviceName in %s", flagFile)
+		}
+		containers, _ := config["containers"].(map[string]any)
+		if _, ok := containers[app].(map[string]any); !ok {
+			return errors.Errorf("cannot find container %q in %s", app, flagFile)
+		}
+
+		version := query.VersionImageTag(cmd.Context())
+		logger := slog.With(slog.String("name", app), slog.String("version", version))
+		logger.Info("Build app")
+
+		source := app
+		if flagSource != "" {
+			source = flagSource
+		}
+		image := app + ":" + version
+
+		docker := []string{
+			"build",
+			"-f", source + "/Dockerfile",
+			"-t", image,
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := os.Stat(filepath.Join(home, ".npmrc")); err != nil && !os.IsNotExist(err) {
+		} else if err == nil {
+			docker = append(docker, "--secret", "id=npmrc,src="+filepath.Join(home, ".npmrc"))
+		}
+
+		docker = append(docker, ".") // build context
+
+		build := exec.CommandContext(cmd.Context(), "docker", docker...)
+		build.Stdout = os.Stdout
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			return errors.Trace(err)
+		}
+
+		logger.Info("Push to Lightsail Containers", slog.String("service", serviceName))
+		push := exec.CommandContext(
+			cmd.Context(),
+			"aws", "lightsail", "push-container-image",
+			"--service-name", serviceName,
+			"--label", app,
+			"--image", image,
+			"--region", flagRegion,
+			"--no-cli-pager",
+		)
+		var buf bytes.Buffer
+		push.Stdout = io.MultiWriter(os.Stdout, &buf)
+		push.Stderr = os.Stderr
+		if err := push.Run(); err != nil {
+			return errors.Trace(err)
+		}
+		match := reLightsailImage.FindStringSubmatch(buf.String())
+		if match == nil {
+			return errors.Errorf("cannot find the pushed image reference in the Lightsail output")
+		}
+
+		logger.Info("Configure pushed image", slog.String("file", flagFile), slog.String("image", match[1]))
+		content, err = replaceJSONString(content, match[1], "containers", app, "image")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := os.WriteFile(flagFile, content, info.Mode().Perm()); err != nil {
+			return errors.Trace(err)
+		}
+
+		return nil
+	}
+}
+
+// replaceJSONString changes the string value found following the keys of path in
+// the JSON content. The rest of the content, including the order of the keys and
+// the formatting, is kept as is.
+func replaceJSONString(content []byte, value string, path ...string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	start, end, err := findJSONString(dec, content, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(content[:start])
+	buf.Write(encoded)
+	buf.Write(content[end:])
+	return buf.Bytes(), nil
+}
+
+func findJSONString(dec *json.Decoder, content []byte, path []string) (int64, int64, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if tok != json.Delim('{') {
+		return 0, 0, errors.Errorf("%s should be an object", path[0])
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, 0, errors.Trace(err)
+		}
+		if key != path[0] {
+			if err := skipJSONValue(dec); err != nil {
+				return 0, 0, errors.Trace(err)
+			}
+			continue
+		}
+		if len(path) > 1 {
+			return findJSONString(dec, content, path[1:])
+		}
+
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, errors.Trace(err)
+		}
+		if _, ok := tok.(string); !ok {
+			return 0, 0, errors.Errorf("%s should be a string, got %v", path[0], tok)
+		}
+		end := dec.InputOffset()
+		// Skip the colon and whitespace between the key and the value.
+		start := before + int64(bytes.IndexByte(content[before:end], '"'))
+		return start, end, nil
+	}
+	return 0, 0, errors.Errorf("cannot find key %q", path[0])
+}
+
+func skipJSONValue(dec *json.Decoder) error {
+	var depth int
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReplaceJSONString(t *testing.T) {
+	content := `{
+  "serviceName": "foo",
+  "containers": {
+    "bar": {
+      "image": ":foo.bar.1",
+      "ports": {"8080": "HTTP"}
+    },
+    "foo": {
+      "command": [],
+      "image": ":foo.foo.1",
+      "environment": {
+        "IMAGE": "keep"
+      }
+    }
+  },
+  "publicEndpoint": {"containerName": "foo", "containerPort": 8080}
+}
+`
+	expected := `{
+  "serviceName": "foo",
+  "containers": {
+    "bar": {
+      "image": ":foo.bar.1",
+      "ports": {"8080": "HTTP"}
+    },
+    "foo": {
+      "command": [],
+      "image": ":foo.foo.2",
+      "environment": {
+        "IMAGE": "keep"
+      }
+    }
+  },
+  "publicEndpoint": {"containerName": "foo", "containerPort": 8080}
+}
+`
+	result, err := replaceJSONString([]byte(content), ":foo.foo.2", "containers", "foo", "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != expected {
+		t.Errorf("unexpected result:\n%s", result)
+	}
+}
+
+func TestReplaceJSONStringMissing(t *testing.T) {
+	content := `{"containers": {"foo": {"image": ":foo.foo.1"}}}`
+	if _, err := replaceJSONString([]byte(content), ":foo.bar.2", "containers", "bar", "image"); err == nil {
+		t.Error("expected an error for a missing container")
+	}
+	if _, err := replaceJSONString([]byte(`{"containers": {"foo": {"image": 3}}}`), ":foo.foo.2", "containers", "foo", "image"); err == nil {
+		t.Error("expected an error for an image that is not a string")
+	}
+}
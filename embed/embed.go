@@ -1,6 +1,25 @@
 package embed
 
-import _ "embed"
+import (
+	goembed "embed"
+)
 
-//go:embed wave.jsonnet
-var Wave []byte
+// WaveV1 is served as wave/v1.jsonnet and as wave.jsonnet for the scripts that
+// do not import a version.
+//
+//go:embed wave/v1.jsonnet
+var WaveV1 []byte
+
+//go:embed wave/v2.jsonnet
+var WaveV2 []byte
+
+// Policies contains the default rules for the --policy flag of wave kubernetes.
+//
+//go:embed wave/policies.jsonnet
+var Policies []byte
+
+// Schemas contains the OpenAPI definitions of every supported Kubernetes version
+// as schemas/kubernetes-<version>.json. They are generated with genschemas.
+//
+//go:embed schemas
+var Schemas goembed.FS
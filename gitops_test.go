@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// initGitOps creates a bare repository with an initial commit in the main branch
+// and returns it with a local clone.
+func initGitOps(t *testing.T) (string, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	t.Setenv("WAVE_VERSION", "v20261019.1")
+	t.Setenv("GERRIT_CHANGE_NUMBER", "123")
+	t.Setenv("GERRIT_PATCHSET_NUMBER", "4")
+
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	clone := filepath.Join(dir, "clone")
+	runGit(t, dir, "init", "--quiet", "--bare", remote)
+	runGit(t, dir, "clone", "--quiet", remote, clone)
+	runGit(t, clone, "checkout", "--quiet", "-b", "main")
+	runGit(t, clone, "commit", "--quiet", "--allow-empty", "-m", "Initial commit")
+	runGit(t, clone, "push", "--quiet", "origin", "main")
+	return remote, clone
+}
+
+func TestPublishGitOps(t *testing.T) {
+	remote, clone := initGitOps(t)
+
+	if err := publishGitOps(context.Background(), testConfigMaps("foo"), "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := runGit(t, remote, "rev-list", "--count", "main"); count != "2" {
+		t.Errorf("unexpected number of commits: %s", count)
+	}
+	files := runGit(t, remote, "ls-tree", "-r", "--name-only", "main")
+	if !strings.Contains(files, "apps/foo/configmap-foo.yaml") {
+		t.Errorf("missing object in the pushed commit:\n%s", files)
+	}
+	msg := runGit(t, remote, "log", "-1", "--format=%B", "main")
+	for _, want := range []string{"Version: v20261019.1", "Gerrit-Change: 123,4", "Script: app.jsonnet"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("commit message does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestPublishGitOpsNoChanges(t *testing.T) {
+	remote, clone := initGitOps(t)
+
+	for i := 0; i < 2; i++ {
+		if err := publishGitOps(context.Background(), testConfigMaps("foo"), "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count := runGit(t, remote, "rev-list", "--count", "main"); count != "2" {
+		t.Errorf("rendering the same objects should not commit anything: %s commits", count)
+	}
+}
+
+func TestPublishGitOpsBranch(t *testing.T) {
+	remote, clone := initGitOps(t)
+
+	if err := publishGitOps(context.Background(), testConfigMaps("foo"), "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo", Branch: "deploy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := runGit(t, remote, "ls-tree", "-r", "--name-only", "deploy")
+	if !strings.Contains(files, "apps/foo/configmap-foo.yaml") {
+		t.Errorf("missing object in the deploy branch:\n%s", files)
+	}
+	if count := runGit(t, remote, "rev-list", "--count", "main"); count != "1" {
+		t.Errorf("the main branch should not change: %s commits", count)
+	}
+}
+
+func TestPublishGitOpsDetachedHead(t *testing.T) {
+	_, clone := initGitOps(t)
+	runGit(t, clone, "checkout", "--quiet", "--detach")
+
+	if err := publishGitOps(context.Background(), testConfigMaps("foo"), "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo"}); err == nil {
+		t.Errorf("detached HEAD without branch should fail")
+	}
+}
+
+func TestPublishGitOpsSecrets(t *testing.T) {
+	remote, clone := initGitOps(t)
+	list := testConfigMaps("foo")
+	list.Items = append(list.Items, map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]any{"name": "foo-secret"},
+		"data":       map[string]any{"password": "Zm9v"},
+	})
+	list.paths = append(list.paths, "secret")
+
+	err := publishGitOps(context.Background(), list, "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo"})
+	if err == nil || !strings.Contains(err.Error(), "Secret foo-secret") {
+		t.Fatalf("plain secrets should be refused: %v", err)
+	}
+	if count := runGit(t, remote, "rev-list", "--count", "main"); count != "1" {
+		t.Errorf("nothing should be published: %s commits", count)
+	}
+
+	if err := publishGitOps(context.Background(), list, "app.jsonnet", gitOpsOptions{Repo: clone, Path: "apps/foo", AllowSecrets: true}); err != nil {
+		t.Fatal(err)
+	}
+	files := runGit(t, remote, "ls-tree", "-r", "--name-only", "main")
+	if !strings.Contains(files, "apps/foo/secret-foo-secret.yaml") {
+		t.Errorf("missing secret in the pushed commit:\n%s", files)
+	}
+}
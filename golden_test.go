@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoldenV2(t *testing.T) {
+	filename := filepath.Join("testdata", "test-v2.jsonnet")
+	list, err := runScript(context.Background(), filename, RunOptions{
+		NativeFuncs: stubNativeFuncs(),
+		Version:     goldenVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := list.EncodeYAML(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := goldenFilename(filename, "")
+	if os.Getenv("UPDATE_GOLDEN") == "true" {
+		if err := os.WriteFile(golden, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err := goldenDiff(golden, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("output does not match the golden file, run the tests with UPDATE_GOLDEN=true to accept the changes:\n%s", diff)
+	}
+}
+
+func TestV2RemovedHelpers(t *testing.T) {
+	helpers := []string{
+		"identities.Azure(name='foo', resourceID='foo', clientID='foo')",
+		"identities.AzureBind(name='foo')",
+		"identities.Google(name='foo')",
+		"identities.GoogleBind()",
+		"identities.MigrateAzure(name='foo', clientID='foo')",
+		"identities.MigrateGoogle(name='foo', serviceAccount='foo')",
+	}
+	for _, helper := range helpers {
+		t.Run(helper, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "script.jsonnet")
+			script := "local wave = import 'wave/v2.jsonnet';\n{ foo: wave." + helper + " }\n"
+			if err := os.WriteFile(filename, []byte(script), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := runScript(context.Background(), filename, RunOptions{
+				NativeFuncs: stubNativeFuncs(),
+				Version:     goldenVersion,
+			})
+			if err == nil || !strings.Contains(err.Error(), "was removed in wave/v2.jsonnet") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestV2SealedSecretErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		err    string
+	}{
+		{
+			name:   "data without certificate",
+			secret: "name='foo', namespace='foo', data={ foo: std.base64('foo') }",
+			err:    "needs the certificate to seal the data",
+		},
+		{
+			name:   "same keys",
+			secret: "name='foo', namespace='foo', certificate='foo.pem', data={ foo: std.base64('foo') }, encryptedData={ foo: 'AgBy' }",
+			err:    "has the same keys in data and encryptedData",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "script.jsonnet")
+			script := "local wave = import 'wave/v2.jsonnet';\n{ foo: wave.objects.SealedSecret(" + test.secret + ") }\n"
+			if err := os.WriteFile(filename, []byte(script), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := runScript(context.Background(), filename, RunOptions{
+				NativeFuncs: stubNativeFuncs(),
+				Version:     goldenVersion,
+			})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
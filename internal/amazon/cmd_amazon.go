@@ -0,0 +1,15 @@
+package amazon
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "aws",
+	Short: "Manage AWS deployments",
+}
+
+func init() {
+	Cmd.AddCommand(cmdECS)
+	Cmd.AddCommand(cmdAppRunner)
+}
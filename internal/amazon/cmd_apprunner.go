@@ -0,0 +1,15 @@
+package amazon
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdAppRunner = &cobra.Command{
+	Use:     "app-runner",
+	Aliases: []string{"apprunner"},
+	Short:   "Manage AWS App Runner deployments",
+}
+
+func init() {
+	cmdAppRunner.AddCommand(cmdAppRunnerDeploy)
+}
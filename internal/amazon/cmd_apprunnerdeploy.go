@@ -0,0 +1,117 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/altipla-consulting/wave/internal/aws"
+	"github.com/altipla-consulting/wave/internal/query"
+)
+
+var cmdAppRunnerDeploy = &cobra.Command{
+	Use:     "deploy",
+	Short:   "Deploy a new image to an App Runner service.",
+	Example: "wave aws app-runner deploy foo --repo 123456789012.dkr.ecr.eu-west-1.amazonaws.com --sentry foo",
+	Args:    cobra.ExactArgs(1),
+}
+
+func init() {
+	var flagRegion, flagRepo, flagSentry string
+	var flagTimeout time.Duration
+	cmdAppRunnerDeploy.Flags().StringVar(&flagRegion, "region", "eu-west-1", "AWS region where the service runs.")
+	cmdAppRunnerDeploy.Flags().StringVar(&flagRepo, "repo", "", "ECR repository name where the container is stored. Use wave ecr to upload it previously.")
+	cmdAppRunnerDeploy.Flags().StringVar(&flagSentry, "sentry", "", "Name of the sentry project to configure.")
+	cmdAppRunnerDeploy.Flags().DurationVar(&flagTimeout, "timeout", 20*time.Minute, "Maximum time to wait for the update to complete.")
+	cmdAppRunnerDeploy.MarkFlagRequired("repo")
+	cmdAppRunnerDeploy.MarkFlagRequired("sentry")
+
+	cmdAppRunnerDeploy.RunE = func(cmd *cobra.Command, args []string) error {
+		app := args[0]
+
+		version := query.Version(cmd.Context())
+		logger := slog.With(slog.String("name", app), slog.String("version", version))
+		logger.Info("Deploy app")
+
+		dsn, err := sentryDSN(flagSentry)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		client, err := aws.NewClient(cmd.Context(), flagRegion)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		arn, err := client.FindAppRunnerService(cmd.Context(), app)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		source, err := client.DescribeAppRunnerService(cmd.Context(), arn)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		repository, ok := source["ImageRepository"].(map[string]any)
+		if !ok {
+			return errors.Errorf("App Runner service %s is not deployed from an image repository", app)
+		}
+		repository["ImageIdentifier"] = fmt.Sprintf("%s/%s:%s", flagRepo, app, query.VersionImageTag(cmd.Context()))
+		config, ok := repository["ImageConfiguration"].(map[string]any)
+		if !ok {
+			config = make(map[string]any)
+			repository["ImageConfiguration"] = config
+		}
+		vars, ok := config["RuntimeEnvironmentVariables"].(map[string]any)
+		if !ok {
+			vars = make(map[string]any)
+			config["RuntimeEnvironmentVariables"] = vars
+		}
+		vars["VERSION"] = version
+		vars["SENTRY_DSN"] = dsn
+
+		logger.Info("Update service")
+		operation, err := client.UpdateAppRunnerService(cmd.Context(), arn, source)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		logger.Info("Wait for the update to complete")
+		ctx, cancel := context.WithTimeout(cmd.Context(), flagTimeout)
+		defer cancel()
+		for {
+			op, err := client.AppRunnerOperation(ctx, arn, operation)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			slog.Debug("Operation status", slog.String("status", op.Status))
+
+			switch op.Status {
+			case "PENDING", "IN_PROGRESS":
+				fmt.Print(".")
+				os.Stdout.Sync()
+			case "SUCCEEDED":
+				fmt.Println()
+				logger.Info("Update completed successfully!")
+				return nil
+			case "FAILED", "ROLLBACK_IN_PROGRESS", "ROLLBACK_SUCCEEDED", "ROLLBACK_FAILED":
+				fmt.Println()
+				return errors.Errorf("App Runner update failed with status %s. Read the AWS Console logs for more information.", op.Status)
+			default:
+				fmt.Println()
+				return errors.Errorf("unknown operation status %q when reading from wave", op.Status)
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Println()
+				return errors.Errorf("timeout waiting for the App Runner service %s to update", app)
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
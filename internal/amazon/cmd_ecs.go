@@ -0,0 +1,14 @@
+package amazon
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdECS = &cobra.Command{
+	Use:   "ecs",
+	Short: "Manage Amazon ECS deployments",
+}
+
+func init() {
+	cmdECS.AddCommand(cmdECSDeploy)
+}
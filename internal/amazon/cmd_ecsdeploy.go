@@ -0,0 +1,183 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/altipla-consulting/wave/internal/aws"
+	"github.com/altipla-consulting/wave/internal/query"
+)
+
+var cmdECSDeploy = &cobra.Command{
+	Use:     "deploy",
+	Short:   "Deploy a new task definition revision to an ECS service.",
+	Example: "wave aws ecs deploy foo --cluster foo-cluster --repo 123456789012.dkr.ecr.eu-west-1.amazonaws.com --sentry foo",
+	Args:    cobra.ExactArgs(1),
+}
+
+func init() {
+	var flagCluster, flagRegion, flagRepo, flagContainer, flagSentry string
+	var flagTimeout time.Duration
+	cmdECSDeploy.Flags().StringVar(&flagCluster, "cluster", "", "ECS cluster where the service runs.")
+	cmdECSDeploy.Flags().StringVar(&flagRegion, "region", "eu-west-1", "AWS region where the service runs.")
+	cmdECSDeploy.Flags().StringVar(&flagRepo, "repo", "", "ECR repository name where the container is stored. Use wave ecr to upload it previously.")
+	cmdECSDeploy.Flags().StringVar(&flagContainer, "container", "", "Name of the container to update inside the task definition. Defaults to the name of the service.")
+	cmdECSDeploy.Flags().StringVar(&flagSentry, "sentry", "", "Name of the sentry project to configure.")
+	cmdECSDeploy.Flags().DurationVar(&flagTimeout, "timeout", 20*time.Minute, "Maximum time to wait for the service to reach a steady state.")
+	cmdECSDeploy.MarkFlagRequired("cluster")
+	cmdECSDeploy.MarkFlagRequired("repo")
+	cmdECSDeploy.MarkFlagRequired("sentry")
+
+	cmdECSDeploy.RunE = func(cmd *cobra.Command, args []string) error {
+		app := args[0]
+		if flagContainer == "" {
+			flagContainer = app
+		}
+
+		version := query.Version(cmd.Context())
+		logger := slog.With(slog.String("name", app), slog.String("version", version))
+		logger.Info("Deploy app")
+
+		dsn, err := sentryDSN(flagSentry)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		client, err := aws.NewClient(cmd.Context(), flagRegion)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		service, err := client.DescribeECSService(cmd.Context(), flagCluster, app)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		td, err := client.DescribeTaskDefinition(cmd.Context(), service.TaskDefinition)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		containers, _ := td["containerDefinitions"].([]any)
+		var container map[string]any
+		for _, c := range containers {
+			if c, ok := c.(map[string]any); ok && c["name"] == flagContainer {
+				container = c
+			}
+		}
+		if container == nil {
+			return errors.Errorf("cannot find container %q in the task definition %s", flagContainer, service.TaskDefinition)
+		}
+		container["image"] = fmt.Sprintf("%s/%s:%s", flagRepo, app, query.VersionImageTag(cmd.Context()))
+		container["environment"] = setEnvironment(container["environment"], map[string]string{
+			"VERSION":    version,
+			"SENTRY_DSN": dsn,
+		})
+
+		logger.Info("Register new task definition revision")
+		arn, err := client.RegisterTaskDefinition(cmd.Context(), td)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		logger.Info("Update service", slog.String("task-definition", arn))
+		if err := client.UpdateECSService(cmd.Context(), flagCluster, app, arn); err != nil {
+			return errors.Trace(err)
+		}
+
+		logger.Info("Wait for the service to reach a steady state")
+		ctx, cancel := context.WithTimeout(cmd.Context(), flagTimeout)
+		defer cancel()
+		seen := make(map[string]bool)
+		for _, event := range service.Events {
+			seen[event.ID] = true
+		}
+		var lastEvent string
+		var dots bool
+		timeout := func() error {
+			fmt.Println()
+			if lastEvent == "" {
+				return errors.Errorf("timeout waiting for the ECS service %s to reach a steady state", app)
+			}
+			return errors.Errorf("timeout waiting for the ECS service %s to reach a steady state, last event: %s", app, lastEvent)
+		}
+		for {
+			service, err := client.DescribeECSService(ctx, flagCluster, app)
+			if err != nil {
+				if ctx.Err() != nil && cmd.Context().Err() == nil {
+					return timeout()
+				}
+				return errors.Trace(err)
+			}
+			// Events are returned from newest to oldest.
+			for i := len(service.Events) - 1; i >= 0; i-- {
+				if event := service.Events[i]; !seen[event.ID] {
+					seen[event.ID] = true
+					lastEvent = event.Message
+					if dots {
+						fmt.Println()
+						dots = false
+					}
+					logger.Info("Service event", slog.String("message", event.Message))
+				}
+			}
+
+			var primary *aws.ECSDeployment
+			for _, deployment := range service.Deployments {
+				if deployment.TaskDefinition == arn {
+					primary = deployment
+				}
+			}
+			switch {
+			case primary == nil:
+				fmt.Println()
+				return errors.Errorf("the deployment of %s has been replaced by another one", arn)
+			case primary.RolloutState == "FAILED":
+				fmt.Println()
+				return errors.Errorf("ECS deployment failed: %s", primary.RolloutStateReason)
+			case len(service.Deployments) == 1 && primary.RunningCount == primary.DesiredCount:
+				fmt.Println()
+				logger.Info("Service reached a steady state!")
+				return nil
+			default:
+				fmt.Print(".")
+				os.Stdout.Sync()
+				dots = true
+			}
+
+			select {
+			case <-ctx.Done():
+				return timeout()
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// setEnvironment replaces or appends the variables in a list of ECS environment entries.
+func setEnvironment(environment any, vars map[string]string) []any {
+	entries, _ := environment.([]any)
+	var result []any
+	for _, entry := range entries {
+		if entry, ok := entry.(map[string]any); ok {
+			if _, replaced := vars[fmt.Sprintf("%v", entry["name"])]; replaced {
+				continue
+			}
+		}
+		result = append(result, entry)
+	}
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, map[string]any{"name": name, "value": vars[name]})
+	}
+	return result
+}
@@ -0,0 +1,27 @@
+package amazon
+
+import (
+	"github.com/altipla-consulting/errors"
+	"github.com/atlassian/go-sentry-api"
+
+	"github.com/altipla-consulting/wave/internal/env"
+)
+
+func sentryAPIString(s string) *string {
+	return &s
+}
+
+func sentryDSN(project string) (string, error) {
+	client, err := sentry.NewClient(env.SentryAuthToken(), nil, nil)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	org := sentry.Organization{
+		Slug: sentryAPIString("altipla"),
+	}
+	keys, err := client.GetClientKeys(org, sentry.Project{Slug: sentryAPIString(project)})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return keys[0].DSN.Public, nil
+}
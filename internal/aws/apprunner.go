@@ -0,0 +1,96 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/altipla-consulting/errors"
+)
+
+const appRunnerPrefix = "AppRunner."
+
+type AppRunnerOperation struct {
+	ID     string `json:"Id"`
+	Type   string `json:"Type"`
+	Status string `json:"Status"`
+}
+
+// FindAppRunnerService returns the ARN of the service with that name.
+func (client *Client) FindAppRunnerService(ctx context.Context, name string) (string, error) {
+	var token string
+	for {
+		input := map[string]any{}
+		if token != "" {
+			input["NextToken"] = token
+		}
+		var reply struct {
+			ServiceSummaryList []struct {
+				ServiceName string `json:"ServiceName"`
+				ServiceArn  string `json:"ServiceArn"`
+			} `json:"ServiceSummaryList"`
+			NextToken string `json:"NextToken"`
+		}
+		if err := client.call(ctx, "apprunner", appRunnerPrefix+"ListServices", input, &reply); err != nil {
+			return "", errors.Trace(err)
+		}
+		for _, summary := range reply.ServiceSummaryList {
+			if summary.ServiceName == name {
+				return summary.ServiceArn, nil
+			}
+		}
+		if reply.NextToken == "" {
+			return "", errors.Errorf("cannot find App Runner service %s", name)
+		}
+		token = reply.NextToken
+	}
+}
+
+// DescribeAppRunnerService returns the raw source configuration of the service to
+// preserve every field when updating it.
+func (client *Client) DescribeAppRunnerService(ctx context.Context, serviceArn string) (map[string]any, error) {
+	input := map[string]any{
+		"ServiceArn": serviceArn,
+	}
+	var reply struct {
+		Service struct {
+			SourceConfiguration map[string]any `json:"SourceConfiguration"`
+		} `json:"Service"`
+	}
+	if err := client.call(ctx, "apprunner", appRunnerPrefix+"DescribeService", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.Service.SourceConfiguration, nil
+}
+
+// UpdateAppRunnerService sends the new source configuration and returns the ID
+// of the operation that applies it.
+func (client *Client) UpdateAppRunnerService(ctx context.Context, serviceArn string, sourceConfiguration map[string]any) (string, error) {
+	input := map[string]any{
+		"ServiceArn":          serviceArn,
+		"SourceConfiguration": sourceConfiguration,
+	}
+	var reply struct {
+		OperationID string `json:"OperationId"`
+	}
+	if err := client.call(ctx, "apprunner", appRunnerPrefix+"UpdateService", input, &reply); err != nil {
+		return "", errors.Trace(err)
+	}
+	return reply.OperationID, nil
+}
+
+func (client *Client) AppRunnerOperation(ctx context.Context, serviceArn, operationID string) (*AppRunnerOperation, error) {
+	input := map[string]any{
+		"ServiceArn": serviceArn,
+	}
+	var reply struct {
+		OperationSummaryList []*AppRunnerOperation `json:"OperationSummaryList"`
+	}
+	if err := client.call(ctx, "apprunner", appRunnerPrefix+"ListOperations", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, op := range reply.OperationSummaryList {
+		if op.ID == operationID {
+			return op, nil
+		}
+	}
+	return nil, errors.Errorf("cannot find App Runner operation %s", operationID)
+}
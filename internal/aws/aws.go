@@ -0,0 +1,226 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// Credentials to sign the requests to AWS.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Client sends JSON requests signed with SigV4 to the AWS APIs without
+// requiring the AWS CLI to be installed.
+type Client struct {
+	Region      string
+	Credentials Credentials
+
+	// Endpoint overrides the URL of every service. Useful to point the client
+	// to a local stand-in of the AWS APIs.
+	Endpoint string
+
+	HTTPClient *http.Client
+
+	// now replaces the clock when signing the requests in the tests.
+	now func() time.Time
+}
+
+// NewClient builds a new client reading the credentials from the same sources
+// of the AWS SDKs.
+func NewClient(ctx context.Context, region string) (*Client, error) {
+	creds, err := readCredentials(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Client{
+		Region:      region,
+		Credentials: creds,
+		Endpoint:    os.Getenv("AWS_ENDPOINT_URL"),
+		HTTPClient:  http.DefaultClient,
+	}, nil
+}
+
+// Error returned by the AWS APIs.
+type Error struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("aws: %s (status %d): %s", err.Type, err.StatusCode, err.Message)
+}
+
+// call sends a request using the JSON protocol of AWS to the service.
+func (client *Client) call(ctx context.Context, service, target string, input, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	endpoint := client.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", serviceHost(service), client.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-"+jsonVersion(service))
+	req.Header.Set("X-Amz-Target", target)
+	client.sign(req, service, body)
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type         string `json:"__type"`
+			Message      string `json:"message"`
+			MessageUpper string `json:"Message"`
+		}
+		_ = json.Unmarshal(reply, &apiErr)
+		msg := apiErr.Message
+		if msg == "" {
+			msg = apiErr.MessageUpper
+		}
+		if msg == "" {
+			msg = string(reply)
+		}
+		errType := apiErr.Type
+		if i := strings.LastIndex(errType, "#"); i >= 0 {
+			errType = errType[i+1:]
+		}
+		return errors.Trace(&Error{
+			StatusCode: resp.StatusCode,
+			Type:       errType,
+			Message:    msg,
+		})
+	}
+
+	if output == nil {
+		return nil
+	}
+	return errors.Trace(json.Unmarshal(reply, output))
+}
+
+func serviceHost(service string) string {
+	switch service {
+	case "ecr":
+		return "api.ecr"
+	default:
+		return service
+	}
+}
+
+func jsonVersion(service string) string {
+	switch service {
+	case "apprunner":
+		return "1.0"
+	default:
+		return "1.1"
+	}
+}
+
+// sign adds the SigV4 authorization headers to the request.
+func (client *Client) sign(req *http.Request, service string, body []byte) {
+	now := time.Now
+	if client.now != nil {
+		now = client.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if client.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", client.Credentials.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, client.Region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+client.Credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, client.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		client.Credentials.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
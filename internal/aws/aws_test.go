@@ -0,0 +1,125 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// TestSignVanilla checks the get-vanilla case of the SigV4 test suite of AWS.
+func TestSignVanilla(t *testing.T) {
+	client := &Client{
+		Region: "us-east-1",
+		Credentials: Credentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		},
+		now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.sign(req, "service", nil)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("unexpected authorization:\ngot:  %s\nwant: %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("unexpected date: %s", got)
+	}
+}
+
+func TestSignSessionToken(t *testing.T) {
+	client := &Client{
+		Region:      "eu-west-1",
+		Credentials: Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"},
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://ecs.eu-west-1.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.sign(req, "ecs", []byte("{}"))
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("unexpected session token: %q", got)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("session token is not signed: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestCallJSONVersion(t *testing.T) {
+	tests := []struct {
+		service     string
+		contentType string
+	}{
+		{"apprunner", "application/x-amz-json-1.0"},
+		{"ecr", "application/x-amz-json-1.1"},
+		{"ecs", "application/x-amz-json-1.1"},
+		{"lightsail", "application/x-amz-json-1.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.service, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Content-Type"); got != test.contentType {
+					t.Errorf("unexpected content type: %s", got)
+				}
+				if got := r.Header.Get("X-Amz-Target"); got != "Service.Operation" {
+					t.Errorf("unexpected target: %s", got)
+				}
+				if !strings.Contains(r.Header.Get("Authorization"), "/"+test.service+"/aws4_request") {
+					t.Errorf("unexpected authorization: %s", r.Header.Get("Authorization"))
+				}
+				var input map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+					t.Fatal(err)
+				}
+				json.NewEncoder(w).Encode(map[string]string{"echo": input["name"]})
+			}))
+			defer server.Close()
+
+			client := &Client{
+				Region:      "eu-west-1",
+				Credentials: Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+				Endpoint:    server.URL,
+			}
+			var reply struct {
+				Echo string `json:"echo"`
+			}
+			if err := client.call(context.Background(), test.service, "Service.Operation", map[string]string{"name": "foo"}, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Echo != "foo" {
+				t.Errorf("unexpected reply: %q", reply.Echo)
+			}
+		})
+	}
+}
+
+func TestCallError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazonaws.ecs#ServiceNotFoundException","message":"Service not found."}`))
+	}))
+	defer server.Close()
+
+	client := &Client{Region: "eu-west-1", Endpoint: server.URL}
+	err := client.call(context.Background(), "ecs", "Service.Operation", struct{}{}, nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Type != "ServiceNotFoundException" || apiErr.Message != "Service not found." {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+}
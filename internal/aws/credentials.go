@@ -0,0 +1,392 @@
+package aws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// readCredentials follows the default chain of the AWS SDKs:
+//
+//  1. The environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+//  2. The profile of the shared files ~/.aws/credentials and ~/.aws/config with
+//     static keys, a credential_process or a role assumed with the credentials
+//     of a source profile or a credential_source. SSO is not supported.
+//  3. The container credentials of ECS and CodeBuild.
+//  4. The instance metadata service of EC2.
+func readCredentials(ctx context.Context) (Credentials, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	creds, found, err := profileCredentials(ctx, profile)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	if found {
+		return creds, nil
+	}
+	if profile != "" {
+		return Credentials{}, errors.Errorf("missing AWS credentials for profile %q", profile)
+	}
+
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return containerCredentials(ctx)
+	}
+
+	if os.Getenv("AWS_EC2_METADATA_DISABLED") != "true" {
+		creds, err := instanceCredentials(ctx)
+		if err == nil {
+			return creds, nil
+		}
+		slog.Debug("Cannot read the credentials of the EC2 instance", slog.String("error", err.Error()))
+	}
+
+	return Credentials{}, errors.Errorf("missing AWS credentials: configure AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, a profile or an instance role")
+}
+
+// profileCredentials reads the credentials of the profile. It returns false if
+// the profile is not configured.
+func profileCredentials(ctx context.Context, profile string) (Credentials, bool, error) {
+	name := profile
+	if name == "" {
+		name = "default"
+	}
+	return resolveProfile(ctx, name, nil)
+}
+
+// loadProfile merges the settings of the profile in the shared credentials and
+// config files.
+func loadProfile(name string) (map[string]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+
+	settings, err := readProfile(credentialsFile, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	section := "profile " + name
+	if name == "default" {
+		section = name
+	}
+	config, err := readProfile(configFile, section)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for k, v := range config {
+		if _, ok := settings[k]; !ok {
+			settings[k] = v
+		}
+	}
+	return settings, nil
+}
+
+// resolveProfile reads the credentials of the profile following the chain of
+// source profiles of the assumed roles. Chain contains the profiles already
+// visited to detect loops.
+func resolveProfile(ctx context.Context, name string, chain []string) (Credentials, bool, error) {
+	if slices.Contains(chain, name) {
+		return Credentials{}, false, errors.Errorf("loop in the source profiles of AWS profile %q: %s", name, strings.Join(append(chain, name), " -> "))
+	}
+	settings, err := loadProfile(name)
+	if err != nil {
+		return Credentials{}, false, errors.Trace(err)
+	}
+
+	switch {
+	case settings["sso_session"] != "" || settings["sso_start_url"] != "":
+		return Credentials{}, false, errors.Errorf("AWS profile %q uses SSO, which is not supported by wave: export the credentials to the environment with aws configure export-credentials", name)
+
+	case settings["role_arn"] != "":
+		source, err := sourceCredentials(ctx, name, settings, chain)
+		if err != nil {
+			return Credentials{}, false, errors.Trace(err)
+		}
+		creds, err := assumeRole(ctx, source, profileRegion(settings), settings)
+		if err != nil {
+			return Credentials{}, false, errors.Errorf("cannot assume the role of AWS profile %q: %s", name, err)
+		}
+		return creds, true, nil
+
+	case settings["aws_access_key_id"] != "":
+		return staticCredentials(settings), true, nil
+
+	case settings["credential_process"] != "":
+		creds, err := processCredentials(ctx, "sh", "-c", settings["credential_process"])
+		return creds, true, errors.Trace(err)
+	}
+	return Credentials{}, false, nil
+}
+
+// sourceCredentials returns the credentials used to assume the role of the profile.
+func sourceCredentials(ctx context.Context, name string, settings map[string]string, chain []string) (Credentials, error) {
+	switch {
+	case settings["web_identity_token_file"] != "":
+		return Credentials{}, errors.Errorf("AWS profile %q uses web_identity_token_file, which is not supported by wave: export the credentials to the environment", name)
+
+	case settings["mfa_serial"] != "":
+		return Credentials{}, errors.Errorf("AWS profile %q requires MFA, which is not supported by wave: export the credentials to the environment", name)
+
+	case settings["source_profile"] == name:
+		// The profile contains both the keys and the role to assume with them.
+		if settings["aws_access_key_id"] == "" {
+			return Credentials{}, errors.Errorf("AWS profile %q is its own source_profile but does not have static keys", name)
+		}
+		return staticCredentials(settings), nil
+
+	case settings["source_profile"] != "":
+		creds, found, err := resolveProfile(ctx, settings["source_profile"], append(chain, name))
+		if err != nil {
+			return Credentials{}, errors.Trace(err)
+		}
+		if !found {
+			return Credentials{}, errors.Errorf("missing AWS credentials for profile %q, the source_profile of %q", settings["source_profile"], name)
+		}
+		return creds, nil
+
+	case settings["credential_source"] == "Environment":
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+			return Credentials{}, errors.Errorf("AWS profile %q reads the source credentials from the environment, but AWS_ACCESS_KEY_ID is not set", name)
+		}
+		return Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+
+	case settings["credential_source"] == "EcsContainer":
+		return containerCredentials(ctx)
+
+	case settings["credential_source"] == "Ec2InstanceMetadata":
+		return instanceCredentials(ctx)
+
+	case settings["credential_source"] != "":
+		return Credentials{}, errors.Errorf("AWS profile %q has an unknown credential_source %q", name, settings["credential_source"])
+	}
+	return Credentials{}, errors.Errorf("AWS profile %q has a role_arn without source_profile or credential_source", name)
+}
+
+func staticCredentials(settings map[string]string) Credentials {
+	return Credentials{
+		AccessKeyID:     settings["aws_access_key_id"],
+		SecretAccessKey: settings["aws_secret_access_key"],
+		SessionToken:    settings["aws_session_token"],
+	}
+}
+
+// profileRegion returns the region where STS is called for the profile.
+func profileRegion(settings map[string]string) string {
+	for _, region := range []string{settings["region"], os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// readProfile returns the settings of a section of an INI file of AWS. A missing
+// file returns no settings.
+func readProfile(filename, section string) (map[string]string, error) {
+	settings := make(map[string]string)
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			continue
+		}
+		if current != section {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return settings, errors.Trace(scanner.Err())
+}
+
+// processCredentials runs a command that prints the credentials with the format
+// of credential_process.
+func processCredentials(ctx context.Context, name string, args ...string) (Credentials, error) {
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, errors.Errorf("cannot read the AWS credentials from %s: %s", name, err)
+	}
+	var reply struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &reply); err != nil {
+		return Credentials{}, errors.Errorf("cannot read the AWS credentials from %s: %s", name, err)
+	}
+	return Credentials{
+		AccessKeyID:     reply.AccessKeyID,
+		SecretAccessKey: reply.SecretAccessKey,
+		SessionToken:    reply.SessionToken,
+	}, nil
+}
+
+// containerEndpoint is the address of the credentials of the ECS task role.
+const containerEndpoint = "http://169.254.170.2"
+
+func containerCredentials(ctx context.Context) (Credentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = containerEndpoint + uri
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if filename := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return Credentials{}, errors.Trace(err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	creds, err := fetchCredentials(req)
+	if err != nil {
+		return Credentials{}, errors.Errorf("cannot read the container credentials: %s", err)
+	}
+	return creds, nil
+}
+
+// instanceEndpoint is the address of the instance metadata service of EC2.
+const instanceEndpoint = "http://169.254.169.254"
+
+// instanceCredentials reads the credentials of the role of the EC2 instance with
+// IMDSv2.
+func instanceCredentials(ctx context.Context) (Credentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = instanceEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	// Outside of EC2 the address does not answer at all.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := metadataGet(req)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	roles, err := metadataGet(req)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	role, _, _ := strings.Cut(roles, "\n")
+	if role == "" {
+		return Credentials{}, errors.Errorf("the EC2 instance does not have any role")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+role, nil)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return fetchCredentials(req)
+}
+
+func metadataGet(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// fetchCredentials reads the credentials with the format of the container and
+// instance metadata endpoints.
+func fetchCredentials(req *http.Request) (Credentials, error) {
+	body, err := metadataGet(req)
+	if err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	var reply struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal([]byte(body), &reply); err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	if reply.AccessKeyID == "" {
+		return Credentials{}, errors.Errorf("no credentials returned by %s", req.URL)
+	}
+	return Credentials{
+		AccessKeyID:     reply.AccessKeyID,
+		SecretAccessKey: reply.SecretAccessKey,
+		SessionToken:    reply.Token,
+	}, nil
+}
@@ -0,0 +1,271 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// isolateCredentials removes any credential of the machine running the tests.
+func isolateCredentials(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN",
+		"AWS_PROFILE",
+		"AWS_SHARED_CREDENTIALS_FILE",
+		"AWS_CONFIG_FILE",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_EC2_METADATA_SERVICE_ENDPOINT",
+		"AWS_ENDPOINT_URL",
+		"AWS_ENDPOINT_URL_STS",
+		"AWS_REGION",
+		"AWS_DEFAULT_REGION",
+	} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	if err := os.MkdirAll(filepath.Join(home, ".aws"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkCredentials(t *testing.T, want Credentials) {
+	t.Helper()
+	got, err := readCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("unexpected credentials:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestCredentialsEnv(t *testing.T) {
+	isolateCredentials(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"})
+}
+
+func TestCredentialsSharedFile(t *testing.T) {
+	home := isolateCredentials(t)
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), `
+[default]
+aws_access_key_id = AKID-DEFAULT
+aws_secret_access_key = secret-default
+
+[ci]
+aws_access_key_id = AKID-CI
+aws_secret_access_key = secret-ci
+`)
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-DEFAULT", SecretAccessKey: "secret-default"})
+
+	t.Setenv("AWS_PROFILE", "ci")
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-CI", SecretAccessKey: "secret-ci"})
+}
+
+func TestCredentialsProcess(t *testing.T) {
+	home := isolateCredentials(t)
+	writeFile(t, filepath.Join(home, ".aws", "config"), `
+[profile tool]
+credential_process = echo '{"Version": 1, "AccessKeyId": "AKID-PROCESS", "SecretAccessKey": "secret-process", "SessionToken": "token-process"}'
+`)
+	t.Setenv("AWS_PROFILE", "tool")
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-PROCESS", SecretAccessKey: "secret-process", SessionToken: "token-process"})
+}
+
+func TestCredentialsAssumeRole(t *testing.T) {
+	home := isolateCredentials(t)
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), `
+[base]
+aws_access_key_id = AKID-BASE
+aws_secret_access_key = secret-base
+`)
+	writeFile(t, filepath.Join(home, ".aws", "config"), `
+[profile deploy]
+role_arn = arn:aws:iam::123456789012:role/deploy
+source_profile = base
+role_session_name = ci
+external_id = foo-external
+region = eu-west-1
+`)
+	t.Setenv("AWS_PROFILE", "deploy")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]string{
+			"Action":          "AssumeRole",
+			"RoleArn":         "arn:aws:iam::123456789012:role/deploy",
+			"RoleSessionName": "ci",
+			"ExternalId":      "foo-external",
+		} {
+			if got := r.PostForm.Get(key); got != want {
+				t.Errorf("unexpected %s: %q", key, got)
+			}
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKID-BASE/") || !strings.Contains(auth, "/eu-west-1/sts/") {
+			t.Errorf("unexpected authorization: %s", auth)
+		}
+		fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>AKID-ROLE</AccessKeyId>
+      <SecretAccessKey>secret-role</SecretAccessKey>
+      <SessionToken>token-role</SessionToken>
+      <Expiration>2026-10-19T12:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-ROLE", SecretAccessKey: "secret-role", SessionToken: "token-role"})
+}
+
+func TestCredentialsAssumeRoleDenied(t *testing.T) {
+	home := isolateCredentials(t)
+	writeFile(t, filepath.Join(home, ".aws", "config"), `
+[profile deploy]
+role_arn = arn:aws:iam::123456789012:role/deploy
+credential_source = Environment
+`)
+	t.Setenv("AWS_PROFILE", "deploy")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized</Message></Error></ErrorResponse>`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+
+	if _, _, err := profileCredentials(context.Background(), "deploy"); err == nil || !strings.Contains(err.Error(), "AWS_ACCESS_KEY_ID is not set") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID-ENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret-env")
+	if _, _, err := profileCredentials(context.Background(), "deploy"); err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCredentialsUnsupportedProfiles(t *testing.T) {
+	home := isolateCredentials(t)
+	writeFile(t, filepath.Join(home, ".aws", "config"), `
+[profile sso]
+sso_session = foo
+sso_account_id = 123456789012
+sso_role_name = deploy
+
+[profile web]
+role_arn = arn:aws:iam::123456789012:role/deploy
+web_identity_token_file = /var/run/token
+
+[profile mfa]
+role_arn = arn:aws:iam::123456789012:role/deploy
+source_profile = mfa
+mfa_serial = arn:aws:iam::123456789012:mfa/foo
+aws_access_key_id = AKID
+aws_secret_access_key = secret
+
+[profile orphan]
+role_arn = arn:aws:iam::123456789012:role/deploy
+
+[profile a]
+role_arn = arn:aws:iam::123456789012:role/a
+source_profile = b
+
+[profile b]
+role_arn = arn:aws:iam::123456789012:role/b
+source_profile = a
+`)
+
+	tests := map[string]string{
+		"sso":    "uses SSO",
+		"web":    "uses web_identity_token_file",
+		"mfa":    "requires MFA",
+		"orphan": "without source_profile or credential_source",
+		"a":      "loop in the source profiles",
+	}
+	for profile, want := range tests {
+		_, _, err := profileCredentials(context.Background(), profile)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("profile %s: unexpected error: %v", profile, err)
+		}
+	}
+}
+
+func TestCredentialsMissingProfile(t *testing.T) {
+	isolateCredentials(t)
+	t.Setenv("AWS_PROFILE", "missing")
+
+	if _, err := readCredentials(context.Background()); err == nil {
+		t.Errorf("missing profile should fail")
+	}
+}
+
+func TestCredentialsContainer(t *testing.T) {
+	isolateCredentials(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "auth-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"AccessKeyId": "AKID-TASK", "SecretAccessKey": "secret-task", "Token": "token-task"}`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth-token")
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-TASK", SecretAccessKey: "secret-task", SessionToken: "token-task"})
+}
+
+func TestCredentialsInstance(t *testing.T) {
+	isolateCredentials(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("runner-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/runner-role":
+			w.Write([]byte(`{"Code": "Success", "AccessKeyId": "AKID-EC2", "SecretAccessKey": "secret-ec2", "Token": "token-ec2"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+
+	checkCredentials(t, Credentials{AccessKeyID: "AKID-EC2", SecretAccessKey: "secret-ec2", SessionToken: "token-ec2"})
+}
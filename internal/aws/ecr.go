@@ -0,0 +1,47 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// ECRAuthorization contains the credentials to log in to an ECR registry with Docker.
+type ECRAuthorization struct {
+	Username      string
+	Password      string
+	ProxyEndpoint string
+}
+
+// ECRAuthorizationToken requests a new token to log in to the ECR registry of the account.
+func (client *Client) ECRAuthorizationToken(ctx context.Context) (*ECRAuthorization, error) {
+	var reply struct {
+		AuthorizationData []struct {
+			AuthorizationToken string `json:"authorizationToken"`
+			ProxyEndpoint      string `json:"proxyEndpoint"`
+		} `json:"authorizationData"`
+	}
+	if err := client.call(ctx, "ecr", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", struct{}{}, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(reply.AuthorizationData) == 0 {
+		return nil, errors.Errorf("ECR did not return any authorization token")
+	}
+
+	data := reply.AuthorizationData[0]
+	token, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	username, password, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return nil, errors.Errorf("malformed ECR authorization token")
+	}
+	return &ECRAuthorization{
+		Username:      username,
+		Password:      password,
+		ProxyEndpoint: data.ProxyEndpoint,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/altipla-consulting/errors"
+)
+
+const ecsPrefix = "AmazonEC2ContainerServiceV20141113."
+
+type ECSService struct {
+	ServiceName    string           `json:"serviceName"`
+	TaskDefinition string           `json:"taskDefinition"`
+	DesiredCount   int              `json:"desiredCount"`
+	RunningCount   int              `json:"runningCount"`
+	Deployments    []*ECSDeployment `json:"deployments"`
+	Events         []*ECSEvent      `json:"events"`
+}
+
+type ECSDeployment struct {
+	ID                 string `json:"id"`
+	Status             string `json:"status"`
+	TaskDefinition     string `json:"taskDefinition"`
+	DesiredCount       int    `json:"desiredCount"`
+	RunningCount       int    `json:"runningCount"`
+	FailedTasks        int    `json:"failedTasks"`
+	RolloutState       string `json:"rolloutState"`
+	RolloutStateReason string `json:"rolloutStateReason"`
+}
+
+type ECSEvent struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+func (client *Client) DescribeECSService(ctx context.Context, cluster, service string) (*ECSService, error) {
+	input := map[string]any{
+		"cluster":  cluster,
+		"services": []string{service},
+	}
+	var reply struct {
+		Services []*ECSService `json:"services"`
+		Failures []struct {
+			Arn    string `json:"arn"`
+			Reason string `json:"reason"`
+		} `json:"failures"`
+	}
+	if err := client.call(ctx, "ecs", ecsPrefix+"DescribeServices", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(reply.Failures) > 0 {
+		return nil, errors.Errorf("cannot describe ECS service %s: %s", service, reply.Failures[0].Reason)
+	}
+	if len(reply.Services) == 0 {
+		return nil, errors.Errorf("cannot find ECS service %s in cluster %s", service, cluster)
+	}
+	return reply.Services[0], nil
+}
+
+// DescribeTaskDefinition returns the raw task definition to preserve every field
+// when registering a new revision from it.
+func (client *Client) DescribeTaskDefinition(ctx context.Context, taskDefinition string) (map[string]any, error) {
+	input := map[string]any{
+		"taskDefinition": taskDefinition,
+	}
+	var reply struct {
+		TaskDefinition map[string]any `json:"taskDefinition"`
+	}
+	if err := client.call(ctx, "ecs", ecsPrefix+"DescribeTaskDefinition", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.TaskDefinition, nil
+}
+
+// RegisterTaskDefinition registers a new revision and returns its ARN. Read-only
+// fields returned by DescribeTaskDefinition are removed before sending it.
+func (client *Client) RegisterTaskDefinition(ctx context.Context, taskDefinition map[string]any) (string, error) {
+	input := make(map[string]any)
+	for k, v := range taskDefinition {
+		switch k {
+		case "taskDefinitionArn", "revision", "status", "requiresAttributes", "compatibilities", "registeredAt", "registeredBy", "deregisteredAt":
+			continue
+		}
+		input[k] = v
+	}
+	var reply struct {
+		TaskDefinition struct {
+			TaskDefinitionArn string `json:"taskDefinitionArn"`
+		} `json:"taskDefinition"`
+	}
+	if err := client.call(ctx, "ecs", ecsPrefix+"RegisterTaskDefinition", input, &reply); err != nil {
+		return "", errors.Trace(err)
+	}
+	return reply.TaskDefinition.TaskDefinitionArn, nil
+}
+
+func (client *Client) UpdateECSService(ctx context.Context, cluster, service, taskDefinition string) error {
+	input := map[string]any{
+		"cluster":        cluster,
+		"service":        service,
+		"taskDefinition": taskDefinition,
+	}
+	return errors.Trace(client.call(ctx, "ecs", ecsPrefix+"UpdateService", input, nil))
+}
@@ -0,0 +1,85 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/altipla-consulting/errors"
+)
+
+const lightsailPrefix = "Lightsail_20161128."
+
+type ContainerService struct {
+	ContainerServiceName string                       `json:"containerServiceName"`
+	State                string                       `json:"state"`
+	StateDetail          *ContainerServiceStateDetail `json:"stateDetail"`
+	CurrentDeployment    *ContainerServiceDeployment  `json:"currentDeployment"`
+	NextDeployment       *ContainerServiceDeployment  `json:"nextDeployment"`
+}
+
+type ContainerServiceStateDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ContainerServiceDeployment struct {
+	Version    int            `json:"version"`
+	State      string         `json:"state"`
+	Containers map[string]any `json:"containers"`
+}
+
+type ContainerLogEvent struct {
+	Message string `json:"message"`
+}
+
+// CreateContainerServiceDeployment sends a new deployment. The input has the same
+// format as the --cli-input-json file of the equivalent AWS CLI command.
+func (client *Client) CreateContainerServiceDeployment(ctx context.Context, input json.RawMessage) (*ContainerService, error) {
+	var reply struct {
+		ContainerService *ContainerService `json:"containerService"`
+	}
+	if err := client.call(ctx, "lightsail", lightsailPrefix+"CreateContainerServiceDeployment", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.ContainerService, nil
+}
+
+func (client *Client) GetContainerServiceDeployments(ctx context.Context, serviceName string) ([]*ContainerServiceDeployment, error) {
+	input := map[string]string{
+		"serviceName": serviceName,
+	}
+	var reply struct {
+		Deployments []*ContainerServiceDeployment `json:"deployments"`
+	}
+	if err := client.call(ctx, "lightsail", lightsailPrefix+"GetContainerServiceDeployments", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.Deployments, nil
+}
+
+func (client *Client) GetContainerServices(ctx context.Context, serviceName string) ([]*ContainerService, error) {
+	input := map[string]string{
+		"serviceName": serviceName,
+	}
+	var reply struct {
+		ContainerServices []*ContainerService `json:"containerServices"`
+	}
+	if err := client.call(ctx, "lightsail", lightsailPrefix+"GetContainerServices", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.ContainerServices, nil
+}
+
+func (client *Client) GetContainerLog(ctx context.Context, serviceName, containerName string) ([]*ContainerLogEvent, error) {
+	input := map[string]string{
+		"serviceName":   serviceName,
+		"containerName": containerName,
+	}
+	var reply struct {
+		LogEvents []*ContainerLogEvent `json:"logEvents"`
+	}
+	if err := client.call(ctx, "lightsail", lightsailPrefix+"GetContainerLog", input, &reply); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reply.LogEvents, nil
+}
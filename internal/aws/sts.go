@@ -0,0 +1,115 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// assumeRole requests temporary credentials of the role_arn of the profile
+// settings to STS, signing the request with the source credentials.
+func assumeRole(ctx context.Context, source Credentials, region string, settings map[string]string) (Credentials, error) {
+	sessionName := settings["role_session_name"]
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("wave-%d", time.Now().Unix())
+	}
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {settings["role_arn"]},
+		"RoleSessionName": {sessionName},
+	}
+	if settings["external_id"] != "" {
+		params.Set("ExternalId", settings["external_id"])
+	}
+	if settings["duration_seconds"] != "" {
+		if _, err := strconv.Atoi(settings["duration_seconds"]); err != nil {
+			return Credentials{}, errors.Errorf("invalid duration_seconds %q", settings["duration_seconds"])
+		}
+		params.Set("DurationSeconds", settings["duration_seconds"])
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	client := &Client{
+		Region:      region,
+		Credentials: source,
+		Endpoint:    endpoint,
+		HTTPClient:  http.DefaultClient,
+	}
+	var reply struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+	if err := client.query(ctx, "sts", params, &reply); err != nil {
+		return Credentials{}, errors.Trace(err)
+	}
+	return Credentials{
+		AccessKeyID:     reply.Credentials.AccessKeyID,
+		SecretAccessKey: reply.Credentials.SecretAccessKey,
+		SessionToken:    reply.Credentials.SessionToken,
+	}, nil
+}
+
+// query sends a request using the query protocol of AWS to the service and reads
+// the XML reply.
+func (client *Client) query(ctx context.Context, service string, params url.Values, output any) error {
+	body := []byte(params.Encode())
+
+	endpoint := client.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, client.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	client.sign(req, service, body)
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		_ = xml.Unmarshal(reply, &apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = string(reply)
+		}
+		return errors.Trace(&Error{
+			StatusCode: resp.StatusCode,
+			Type:       apiErr.Code,
+			Message:    apiErr.Message,
+		})
+	}
+	return errors.Trace(xml.Unmarshal(reply, output))
+}
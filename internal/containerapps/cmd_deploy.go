@@ -1,10 +1,13 @@
 package containerapps
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
-	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/altipla-consulting/errors"
 	"github.com/atlassian/go-sentry-api"
@@ -22,13 +25,36 @@ var cmdDeploy = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 }
 
+// maxRevisionName is the longest name of a revision accepted by Azure.
+const maxRevisionName = 64
+
 func init() {
 	var flagRepo, flagSubscription, flagResourceGroup string
 	var flagSentry string
+	var flagCPU, flagMemory string
+	var flagMinReplicas, flagMaxReplicas int
+	var flagSecrets []string
+	var flagKeyVaultIdentity string
+	var flagIngress string
+	var flagTargetPort int
+	var flagMultipleRevisions bool
+	var flagTag string
+	var flagTrafficPercent int
 	cmdDeploy.Flags().StringVar(&flagRepo, "repo", "", "Azure Container Registry repository name where the container will be stored.")
 	cmdDeploy.Flags().StringVar(&flagSubscription, "subscription", "", "Azure subscription ID.")
 	cmdDeploy.Flags().StringVar(&flagResourceGroup, "resource-group", "", "Azure resource group where the container has been stored. Use `wave acr` to upload it previously.")
 	cmdDeploy.Flags().StringVar(&flagSentry, "sentry", "", "Name of the sentry project to configure.")
+	cmdDeploy.Flags().StringVar(&flagCPU, "cpu", "", "CPU cores of each replica, for example 0.5. Empty keeps the current value.")
+	cmdDeploy.Flags().StringVar(&flagMemory, "memory", "", "Memory of each replica, for example 1.0Gi. Empty keeps the current value.")
+	cmdDeploy.Flags().IntVar(&flagMinReplicas, "min-replicas", 0, "Minimum number of replicas. Keeps the current value if not set.")
+	cmdDeploy.Flags().IntVar(&flagMaxReplicas, "max-replicas", 0, "Maximum number of replicas. Keeps the current value if not set.")
+	cmdDeploy.Flags().StringArrayVar(&flagSecrets, "secret", nil, "Environment variable read from a Key Vault secret with the format NAME=https://example.vault.azure.net/secrets/name. Repeat it to configure more secrets.")
+	cmdDeploy.Flags().StringVar(&flagKeyVaultIdentity, "key-vault-identity", "system", "Managed identity used to read the Key Vault secrets: system or the resource ID of a user assigned identity.")
+	cmdDeploy.Flags().StringVar(&flagIngress, "ingress", "", "Enable the ingress of the application: external or internal. Empty keeps the current configuration.")
+	cmdDeploy.Flags().IntVar(&flagTargetPort, "target-port", 8080, "Port of the container that receives the traffic of the ingress.")
+	cmdDeploy.Flags().BoolVar(&flagMultipleRevisions, "multiple-revisions", false, "Keep several active revisions to split the traffic between them and label the previews.")
+	cmdDeploy.Flags().StringVar(&flagTag, "tag", "", "Label of the revision included in the URL when using multiple revisions. Defaults to the Gerrit change and patchset.")
+	cmdDeploy.Flags().IntVar(&flagTrafficPercent, "traffic-percent", 100, "Percentage of the traffic sent to the new revision of a release when using multiple revisions. The rest stays in the previous revision.")
 	cmdDeploy.MarkFlagRequired("repo")
 	cmdDeploy.MarkFlagRequired("subscription")
 	cmdDeploy.MarkFlagRequired("resource-group")
@@ -37,6 +63,33 @@ func init() {
 	cmdDeploy.RunE = func(cmd *cobra.Command, args []string) error {
 		app := args[0]
 
+		switch flagIngress {
+		case "", "external", "internal":
+		default:
+			return errors.Errorf("unknown --ingress type: %s", flagIngress)
+		}
+		if flagTrafficPercent < 0 || flagTrafficPercent > 100 {
+			return errors.Errorf("--traffic-percent should be between 0 and 100")
+		}
+		if !flagMultipleRevisions && (flagTag != "" || flagTrafficPercent != 100) {
+			return errors.Errorf("--tag and --traffic-percent need --multiple-revisions")
+		}
+		var secrets, envVars []string
+		refs := make(map[string]string)
+		for _, secret := range flagSecrets {
+			name, uri, ok := strings.Cut(secret, "=")
+			if !ok || name == "" || !strings.HasPrefix(uri, "https://") {
+				return errors.Errorf("malformed secret %q, use NAME=https://example.vault.azure.net/secrets/name", secret)
+			}
+			ref := resourceName(name, 253)
+			if other, ok := refs[ref]; ok {
+				return errors.Errorf("secrets %s and %s use the same secret reference %s in Azure, rename one of them", other, name, ref)
+			}
+			refs[ref] = name
+			secrets = append(secrets, fmt.Sprintf("%s=keyvaultref:%s,identityref:%s", ref, uri, flagKeyVaultIdentity))
+			envVars = append(envVars, fmt.Sprintf("%s=secretref:%s", name, ref))
+		}
+
 		version := query.VersionImageTag(cmd.Context())
 		logger := slog.With(slog.String("name", app), slog.String("version", version))
 		logger.Info("Deploy app")
@@ -53,27 +106,208 @@ func init() {
 			return errors.Trace(err)
 		}
 
-		auth := exec.CommandContext(cmd.Context(), "az", "account", "set", "--subscription", flagSubscription)
-		auth.Stdout = os.Stdout
-		auth.Stderr = os.Stderr
-		if err := auth.Run(); err != nil {
+		if err := az(cmd.Context(), "account", "set", "--subscription", flagSubscription); err != nil {
 			return errors.Trace(err)
 		}
 
-		az := []string{
-			"containerapp", "update",
-			"--name", app,
-			"--resource-group", flagResourceGroup,
+		target := []string{"--name", app, "--resource-group", flagResourceGroup}
+
+		if flagIngress != "" {
+			logger.Info("Configure ingress", slog.String("type", flagIngress))
+			ingress := append([]string{"containerapp", "ingress", "enable"}, target...)
+			ingress = append(ingress, "--type", flagIngress, "--target-port", strconv.Itoa(flagTargetPort), "--transport", "auto")
+			if err := az(cmd.Context(), ingress...); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		if len(secrets) > 0 {
+			logger.Info("Configure Key Vault secrets")
+			set := append([]string{"containerapp", "secret", "set"}, target...)
+			set = append(set, "--secrets")
+			set = append(set, secrets...)
+			if err := az(cmd.Context(), set...); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		var previous, label, replaced string
+		var existing []string
+		if flagMultipleRevisions {
+			mode := append([]string{"containerapp", "revision", "set-mode"}, target...)
+			if err := az(cmd.Context(), append(mode, "--mode", "multiple")...); err != nil {
+				return errors.Trace(err)
+			}
+			show := append([]string{"containerapp", "show"}, target...)
+			previous, err = azOutput(cmd.Context(), append(show, "--query", "properties.latestRevisionName", "--output", "tsv")...)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			list := append([]string{"containerapp", "revision", "list"}, target...)
+			names, err := azOutput(cmd.Context(), append(list, "--all", "--query", "[].name", "--output", "tsv")...)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			existing = strings.Fields(names)
+
+			label = resourceName(query.VersionHostname(flagTag), maxRevisionName)
+			if label != "" {
+				weights, err := showTraffic(cmd.Context(), target)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				for _, weight := range weights {
+					if weight.Label == label {
+						replaced = weight.RevisionName
+					}
+				}
+			}
+			if label != "" && !query.IsRelease() && previous != "" {
+				// Pin the traffic to the current revision before creating the new one
+				// to avoid sending real users to the preview.
+				logger.Info("Keep the traffic in the current revision", slog.String("revision", previous))
+				traffic := append([]string{"containerapp", "ingress", "traffic", "set"}, target...)
+				if err := az(cmd.Context(), append(traffic, "--revision-weight", previous+"=100")...); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		update := append([]string{"containerapp", "update"}, target...)
+		update = append(update,
 			"--image", fmt.Sprintf("%s.azurecr.io/%s:%s", flagRepo, app, version),
 			"--set-env-vars", fmt.Sprintf("VERSION=%s", version), fmt.Sprintf("SENTRY_DSN=%s", keys[0].DSN.Public),
+		)
+		update = append(update, envVars...)
+		if flagCPU != "" {
+			update = append(update, "--cpu", flagCPU)
+		}
+		if flagMemory != "" {
+			update = append(update, "--memory", flagMemory)
+		}
+		if cmd.Flags().Changed("min-replicas") {
+			update = append(update, "--min-replicas", strconv.Itoa(flagMinReplicas))
+		}
+		if cmd.Flags().Changed("max-replicas") {
+			update = append(update, "--max-replicas", strconv.Itoa(flagMaxReplicas))
+		}
+		var revision string
+		if flagMultipleRevisions {
+			suffix := revisionSuffix(app, version, existing)
+			revision = app + "--" + suffix
+			update = append(update, "--revision-suffix", suffix)
 		}
-		deploy := exec.CommandContext(cmd.Context(), "az", az...)
-		deploy.Stdout = os.Stdout
-		deploy.Stderr = os.Stderr
-		if err := deploy.Run(); err != nil {
+		if err := az(cmd.Context(), update...); err != nil {
 			return errors.Trace(err)
 		}
 
+		if !flagMultipleRevisions {
+			return nil
+		}
+
+		if label != "" {
+			logger.Info("Label revision", slog.String("revision", revision), slog.String("label", label))
+			add := append([]string{"containerapp", "revision", "label", "add"}, target...)
+			if err := az(cmd.Context(), append(add, "--label", label, "--revision", revision, "--no-prompt")...); err != nil {
+				return errors.Trace(err)
+			}
+
+			// The label moved to the new revision, the previous preview is not reachable anymore.
+			if replaced != "" && replaced != revision && replaced != previous && !query.IsRelease() {
+				if err := deactivateRevision(cmd.Context(), logger, target, replaced); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		if query.IsRelease() {
+			weights := []string{"latest=" + strconv.Itoa(flagTrafficPercent)}
+			if flagTrafficPercent < 100 {
+				if previous == "" {
+					return errors.Errorf("cannot split the traffic without a previous revision")
+				}
+				weights = append(weights, previous+"="+strconv.Itoa(100-flagTrafficPercent))
+			}
+			logger.Info("Send traffic to the new revision", slog.String("revision", revision), slog.Int("percent", flagTrafficPercent))
+			traffic := append([]string{"containerapp", "ingress", "traffic", "set"}, target...)
+			if err := az(cmd.Context(), append(append(traffic, "--revision-weight"), weights...)...); err != nil {
+				return errors.Trace(err)
+			}
+
+			if err := deactivateUnused(cmd.Context(), logger, target, revision); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
 		return nil
 	}
 }
+
+// revisionSuffix returns the suffix of the new revision. A counter is added if a
+// revision of the same version already exists, for example when redeploying it.
+func revisionSuffix(app, version string, existing []string) string {
+	base := resourceName("v"+version, maxRevisionName-len(app)-len("--")-len("-99"))
+	suffix := base
+	for i := 2; slices.Contains(existing, app+"--"+suffix); i++ {
+		suffix = fmt.Sprintf("%s-%d", base, i)
+	}
+	return suffix
+}
+
+type trafficWeight struct {
+	RevisionName   string `json:"revisionName"`
+	Weight         int    `json:"weight"`
+	Label          string `json:"label"`
+	LatestRevision bool   `json:"latestRevision"`
+}
+
+func showTraffic(ctx context.Context, target []string) ([]trafficWeight, error) {
+	show := append([]string{"containerapp", "ingress", "traffic", "show"}, target...)
+	output, err := azOutput(ctx, append(show, "--output", "json")...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var weights []trafficWeight
+	if err := json.Unmarshal([]byte(output), &weights); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return weights, nil
+}
+
+func deactivateRevision(ctx context.Context, logger *slog.Logger, target []string, revision string) error {
+	logger.Info("Deactivate revision", slog.String("revision", revision))
+	deactivate := append([]string{"containerapp", "revision", "deactivate"}, target...)
+	return errors.Trace(az(ctx, append(deactivate, "--revision", revision)...))
+}
+
+// deactivateUnused deactivates the revisions of previous releases that do not
+// receive traffic anymore. Labeled revisions are kept because they are previews
+// still reachable through their own URL.
+func deactivateUnused(ctx context.Context, logger *slog.Logger, target []string, current string) error {
+	weights, err := showTraffic(ctx, target)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	used := map[string]bool{current: true}
+	for _, weight := range weights {
+		if weight.Weight > 0 || weight.Label != "" || weight.LatestRevision {
+			used[weight.RevisionName] = true
+		}
+	}
+
+	list := append([]string{"containerapp", "revision", "list"}, target...)
+	active, err := azOutput(ctx, append(list, "--query", "[?properties.active].name", "--output", "tsv")...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, revision := range strings.Fields(active) {
+		if used[revision] {
+			continue
+		}
+		if err := deactivateRevision(ctx, logger, target, revision); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
@@ -1,5 +1,50 @@
 package containerapps
 
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+)
+
 func sentryAPIString(s string) *string {
 	return &s
 }
+
+func az(ctx context.Context, args ...string) error {
+	slog.Debug(strings.Join(append([]string{"az"}, args...), " "))
+	cmd := exec.CommandContext(ctx, "az", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return errors.Trace(cmd.Run())
+}
+
+func azOutput(ctx context.Context, args ...string) (string, error) {
+	slog.Debug(strings.Join(append([]string{"az"}, args...), " "))
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "az", args...)
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+var reInvalidNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// resourceName converts the value to a valid name for revisions, labels and
+// secrets: lowercase letters and numbers separated by single dashes.
+func resourceName(s string, maxLength int) string {
+	s = reInvalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	s = strings.Trim(s, "-")
+	if len(s) > maxLength {
+		s = strings.TrimRight(s[:maxLength], "-")
+	}
+	return s
+}
@@ -0,0 +1,36 @@
+package containerapps
+
+import (
+	"testing"
+)
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		value     string
+		maxLength int
+		expected  string
+	}{
+		{"DB_PASS", 253, "db-pass"},
+		{"db-pass", 253, "db-pass"},
+		{"--Foo__Bar--", 253, "foo-bar"},
+		{"v20261019.1+a1b2c3", 64, "v20261019-1-a1b2c3"},
+		{"refs/changes/23/123/4", 64, "refs-changes-23-123-4"},
+		{"foo-bar-baz", 8, "foo-bar"},
+		{"", 64, ""},
+	}
+	for _, test := range tests {
+		if got := resourceName(test.value, test.maxLength); got != test.expected {
+			t.Errorf("resourceName(%q, %d) = %q, expected %q", test.value, test.maxLength, got, test.expected)
+		}
+	}
+}
+
+func TestRevisionSuffix(t *testing.T) {
+	if got := revisionSuffix("foo", "20261019.1", nil); got != "v20261019-1" {
+		t.Errorf("unexpected suffix: %q", got)
+	}
+	existing := []string{"foo--v20261019-1", "foo--v20261019-1-2"}
+	if got := revisionSuffix("foo", "20261019.1", existing); got != "v20261019-1-3" {
+		t.Errorf("unexpected suffix for a redeploy: %q", got)
+	}
+}
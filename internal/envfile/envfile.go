@@ -0,0 +1,279 @@
+package envfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/altipla-consulting/errors"
+	"github.com/joho/godotenv"
+)
+
+// Read returns the variables of the env file. Files encrypted with SOPS or age
+// are decrypted in memory with the age identities of the environment.
+func Read(filename string) (map[string]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch {
+	case isAge(content):
+		identities, err := ageIdentities()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r, err := age.Decrypt(ageReader(content), identities...)
+		if err != nil {
+			return nil, errors.Errorf("cannot decrypt %s: %s", filename, err)
+		}
+		return godotenv.Parse(r)
+
+	case isSOPS(content):
+		values, err := decryptSOPS(parseSOPS(content))
+		if err != nil {
+			return nil, errors.Errorf("cannot decrypt %s: %s", filename, err)
+		}
+		return values, nil
+	}
+	return godotenv.Unmarshal(string(content))
+}
+
+// Keys returns the names of the variables of the env file without decrypting it
+// if possible. Files encrypted with age have no visible keys and return an
+// empty list.
+func Keys(filename string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch {
+	case isAge(content):
+		return nil, nil
+
+	case isSOPS(content):
+		var keys []string
+		for _, line := range parseSOPS(content) {
+			if line.key != "" && !strings.HasPrefix(line.key, sopsPrefix) {
+				keys = append(keys, line.key)
+			}
+		}
+		return keys, nil
+	}
+	m, err := godotenv.Unmarshal(string(content))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+const ageHeader = "age-encryption.org/v1\n"
+
+func isAge(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header))
+}
+
+func ageReader(content []byte) io.Reader {
+	if bytes.HasPrefix(content, []byte(ageHeader)) {
+		return bytes.NewReader(content)
+	}
+	return armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+}
+
+// ageIdentities reads the private keys from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or
+// the default file of SOPS in the user configuration directory.
+func ageIdentities() ([]age.Identity, error) {
+	if keys := os.Getenv("SOPS_AGE_KEY"); keys != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, errors.Errorf("cannot read SOPS_AGE_KEY: %s", err)
+		}
+		return identities, nil
+	}
+
+	filename := os.Getenv("SOPS_AGE_KEY_FILE")
+	if filename == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		filename = filepath.Join(dir, "sops", "age", "keys.txt")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("missing age keys to decrypt the env file: configure SOPS_AGE_KEY or SOPS_AGE_KEY_FILE")
+		}
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Errorf("cannot read %s: %s", filename, err)
+	}
+	return identities, nil
+}
+
+// sopsPrefix starts the keys of the metadata that SOPS appends to the env file.
+const sopsPrefix = "sops_"
+
+func isSOPS(content []byte) bool {
+	for _, line := range parseSOPS(content) {
+		if line.key == sopsPrefix+"mac" {
+			return true
+		}
+	}
+	return false
+}
+
+type sopsLine struct {
+	key     string
+	value   string
+	comment string
+}
+
+// parseSOPS reads the env file in the format written by SOPS. Values are not
+// quoted and the line breaks are escaped.
+func parseSOPS(content []byte) []sopsLine {
+	var lines []sopsLine
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			lines = append(lines, sopsLine{comment: line[1:]})
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		lines = append(lines, sopsLine{
+			key:   key,
+			value: strings.ReplaceAll(value, `\n`, "\n"),
+		})
+	}
+	return lines
+}
+
+func decryptSOPS(lines []sopsLine) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, line := range lines {
+		if strings.HasPrefix(line.key, sopsPrefix) {
+			metadata[strings.TrimPrefix(line.key, sopsPrefix)] = line.value
+		}
+	}
+	key, err := sopsDataKey(metadata)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	values := make(map[string]string)
+	hash := sha512.New()
+	macOnlyEncrypted := metadata["mac_only_encrypted"] == "true"
+	for _, line := range lines {
+		if strings.HasPrefix(line.key, sopsPrefix) {
+			continue
+		}
+		value := line.value
+		if line.key == "" {
+			value = line.comment
+		}
+		encrypted := reSOPSValue.MatchString(value)
+		if encrypted {
+			// The path of the value authenticates the encrypted data. Comments
+			// are at the root of the file and have an empty path.
+			value, err = decryptSOPSValue(value, key, line.key+":")
+			if err != nil {
+				return nil, errors.Errorf("cannot decrypt %s: %s", line.key, err)
+			}
+		}
+		if encrypted || !macOnlyEncrypted {
+			hash.Write([]byte(value))
+		}
+		if line.key != "" {
+			values[line.key] = value
+		}
+	}
+
+	mac, err := decryptSOPSValue(metadata["mac"], key, metadata["lastmodified"])
+	if err != nil {
+		return nil, errors.Errorf("cannot decrypt the MAC: %s", err)
+	}
+	if mac != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return nil, errors.Errorf("MAC mismatch, the file was modified after encrypting it")
+	}
+	return values, nil
+}
+
+// sopsDataKey decrypts the key of the values with the first age recipient of
+// the file that matches our identities.
+func sopsDataKey(metadata map[string]string) ([]byte, error) {
+	identities, err := ageIdentities()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := 0; ; i++ {
+		enc, ok := metadata[fmt.Sprintf("age__list_%d__map_enc", i)]
+		if !ok {
+			break
+		}
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				continue
+			}
+			return nil, errors.Trace(err)
+		}
+		key, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return key, nil
+	}
+	return nil, errors.Errorf("no age identity matches the recipients of the file, only age keys are supported")
+}
+
+var reSOPSValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+func decryptSOPSValue(value string, key []byte, additionalData string) (string, error) {
+	matches := reSOPSValue.FindStringSubmatch(value)
+	if matches == nil {
+		return "", errors.Errorf("malformed encrypted value")
+	}
+	var parts [][]byte
+	for _, match := range matches[1:4] {
+		decoded, err := base64.StdEncoding.DecodeString(match)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		parts = append(parts, decoded)
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,222 @@
+package envfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const testEnv = "FOO=foo\nBAR=multiple words\n"
+
+// configureIdentity generates an age key and exposes it to the package like SOPS
+// does.
+func configureIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOPS_AGE_KEY", identity.String())
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	return identity
+}
+
+func writeEnv(t *testing.T, content []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "prod.env")
+	if err := os.WriteFile(filename, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func encryptAge(t *testing.T, recipient age.Recipient, plaintext []byte, armored bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.WriteCloser = nopCloser{&buf}
+	if armored {
+		out = armor.NewWriter(&buf)
+	}
+	w, err := age.Encrypt(out, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func checkValues(t *testing.T, filename string, want map[string]string) {
+	t.Helper()
+	got, err := Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unexpected values:\ngot:  %v\nwant: %v", got, want)
+	}
+}
+
+func TestReadPlain(t *testing.T) {
+	checkValues(t, writeEnv(t, []byte(testEnv)), map[string]string{"FOO": "foo", "BAR": "multiple words"})
+}
+
+func TestReadAge(t *testing.T) {
+	identity := configureIdentity(t)
+	for _, armored := range []bool{false, true} {
+		t.Run(fmt.Sprintf("armored=%v", armored), func(t *testing.T) {
+			filename := writeEnv(t, encryptAge(t, identity.Recipient(), []byte(testEnv), armored))
+			checkValues(t, filename, map[string]string{"FOO": "foo", "BAR": "multiple words"})
+
+			keys, err := Keys(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Errorf("age files should not expose their keys: %v", keys)
+			}
+		})
+	}
+}
+
+func TestReadAgeWrongIdentity(t *testing.T) {
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureIdentity(t)
+	if _, err := Read(writeEnv(t, encryptAge(t, other.Recipient(), []byte(testEnv), false))); err == nil {
+		t.Errorf("file of another recipient should fail")
+	}
+}
+
+// encryptSOPSValue encrypts a value with the format of SOPS.
+func encryptSOPSValue(t *testing.T, key []byte, value, additionalData string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag))
+}
+
+// encryptSOPS writes the env file like sops --encrypt does with an age recipient.
+func encryptSOPS(t *testing.T, recipient *age.X25519Recipient, lines [][2]string) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	const lastModified = "2026-10-19T10:00:00Z"
+
+	var buf bytes.Buffer
+	hash := sha512.New()
+	for _, line := range lines {
+		k, v := line[0], line[1]
+		hash.Write([]byte(v))
+		if k == "" {
+			fmt.Fprintf(&buf, "#%s\n", encryptSOPSValue(t, key, v, ":"))
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", k, encryptSOPSValue(t, key, v, k+":"))
+	}
+
+	dataKey := encryptAge(t, recipient, key, true)
+	fmt.Fprintf(&buf, "sops_age__list_0__map_enc=%s\n", strings.ReplaceAll(string(dataKey), "\n", `\n`))
+	fmt.Fprintf(&buf, "sops_age__list_0__map_recipient=%s\n", recipient)
+	fmt.Fprintf(&buf, "sops_lastmodified=%s\n", lastModified)
+	fmt.Fprintf(&buf, "sops_mac=%s\n", encryptSOPSValue(t, key, fmt.Sprintf("%X", hash.Sum(nil)), lastModified))
+	fmt.Fprintf(&buf, "sops_unencrypted_suffix=_unencrypted\n")
+	fmt.Fprintf(&buf, "sops_version=3.9.0\n")
+	return buf.Bytes()
+}
+
+func TestReadSOPS(t *testing.T) {
+	identity := configureIdentity(t)
+	filename := writeEnv(t, encryptSOPS(t, identity.Recipient(), [][2]string{
+		{"", " database credentials"},
+		{"DB_USER", "admin"},
+		{"DB_PASSWORD", "s3cret=with=equals"},
+	}))
+
+	checkValues(t, filename, map[string]string{"DB_USER": "admin", "DB_PASSWORD": "s3cret=with=equals"})
+
+	keys, err := Keys(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "DB_PASSWORD,DB_USER" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestReadSOPSTampered(t *testing.T) {
+	identity := configureIdentity(t)
+	content := encryptSOPS(t, identity.Recipient(), [][2]string{
+		{"DB_USER", "admin"},
+		{"DB_PASSWORD", "s3cret"},
+	})
+	// Remove a line, every remaining value is still valid but the MAC is not.
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "DB_USER=") {
+			lines = append(lines, line)
+		}
+	}
+
+	if _, err := Read(writeEnv(t, []byte(strings.Join(lines, "\n")))); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadSOPSWrongIdentity(t *testing.T) {
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureIdentity(t)
+	filename := writeEnv(t, encryptSOPS(t, other.Recipient(), [][2]string{{"FOO", "foo"}}))
+	if _, err := Read(filename); err == nil {
+		t.Errorf("file of another recipient should fail")
+	}
+}
@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/altipla-consulting/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Dir is the folder next to the scripts with a jsonnet library per environment.
+const Dir = "environments"
+
+// ConfigFile inside Dir maps every environment to the cluster where it is deployed:
+//
+//	staging:
+//	  context: gke_example_europe-west1_staging
+//	production:
+//	  context: gke_example_europe-west1_production
+const ConfigFile = "environments.yaml"
+
+type Environment struct {
+	Name string `json:"-"`
+
+	// Library is the jsonnet file exposed to the scripts as std.extVar('env').
+	Library string `json:"-"`
+
+	// Context of kubectl where the environment is deployed. Empty if the environment
+	// is not configured.
+	Context string `json:"context"`
+}
+
+// Load reads the environment of the script.
+func Load(script, name string) (*Environment, error) {
+	dir := filepath.Join(filepath.Dir(script), Dir)
+	env := &Environment{
+		Name:    name,
+		Library: filepath.Join(dir, name+".libsonnet"),
+	}
+	if _, err := os.Stat(env.Library); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("unknown environment %q: cannot find %s", name, env.Library)
+		}
+		return nil, errors.Trace(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return env, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	config := make(map[string]*Environment)
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, errors.Errorf("cannot read %s: %s", filepath.Join(dir, ConfigFile), err)
+	}
+	if c := config[name]; c != nil {
+		env.Context = c.Context
+	}
+	return env, nil
+}
@@ -0,0 +1,288 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// Filter selects objects by the jsonnet path that generated them, their kind or
+// their labels. Expressions accept glob patterns and {a,b} alternatives:
+//
+//	apps.*.deployment
+//	objects.{foo,bar}
+//	kind:Deployment
+//	kind:{Deployment,StatefulSet}
+//	label:app=foo
+//	label:team
+type Filter struct {
+	// segments of the path, each one with its alternatives.
+	segments [][]string
+
+	kinds []string
+
+	label  string
+	values []string
+}
+
+// Parse reads a filter expression.
+func Parse(expr string) (*Filter, error) {
+	f := new(Filter)
+	switch {
+	case strings.HasPrefix(expr, "kind:"):
+		kinds, err := expand(strings.TrimPrefix(expr, "kind:"))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		f.kinds = kinds
+
+	case strings.HasPrefix(expr, "label:"):
+		label, value, ok := strings.Cut(strings.TrimPrefix(expr, "label:"), "=")
+		if label == "" {
+			return nil, errors.Errorf("missing label name in filter %q", expr)
+		}
+		f.label = label
+		if ok {
+			values, err := expand(value)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			f.values = values
+		}
+
+	default:
+		segments, err := split(expr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, segment := range segments {
+			alternatives, err := expand(segment)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			f.segments = append(f.segments, alternatives)
+		}
+	}
+	return f, nil
+}
+
+// MatchPath returns true if the filter selects the whole path, including any
+// object below it. A filter deeper than the path does not select the object
+// generated there: apps.foo.deployment does not select an object generated in
+// apps.foo. The filters before globs were supported selected it because they
+// stopped comparing at the first object found.
+func (f *Filter) MatchPath(parts []string) bool {
+	if len(f.segments) > len(parts) {
+		return false
+	}
+	return f.MayMatch(parts)
+}
+
+// MayMatch returns true if the filter could select some object below the path.
+func (f *Filter) MayMatch(parts []string) bool {
+	for i, alternatives := range f.segments {
+		if i >= len(parts) {
+			break
+		}
+		if !matchAny(alternatives, parts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchObject returns true if the kind or the labels of the object are selected
+// by the filter.
+func (f *Filter) MatchObject(obj map[string]any) bool {
+	if f.kinds != nil {
+		kind, _ := obj["kind"].(string)
+		return matchAny(f.kinds, kind)
+	}
+	metadata, _ := obj["metadata"].(map[string]any)
+	labels, _ := metadata["labels"].(map[string]any)
+	value, ok := labels[f.label]
+	if !ok {
+		return false
+	}
+	return f.values == nil || matchAny(f.values, fmt.Sprint(value))
+}
+
+func (f *Filter) isPath() bool {
+	return f.segments != nil
+}
+
+// Set combines the filters passed by the user. Objects must match any of the
+// path filters, any of the kind filters and any of the label filters that are
+// present, and none of the exclusions.
+type Set struct {
+	paths, kinds, labels []*Filter
+	excludes             []*Filter
+}
+
+// NewSet parses the include and exclude expressions.
+func NewSet(include, exclude []string) (*Set, error) {
+	set := new(Set)
+	for _, expr := range include {
+		f, err := Parse(expr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch {
+		case f.isPath():
+			set.paths = append(set.paths, f)
+		case f.kinds != nil:
+			set.kinds = append(set.kinds, f)
+		default:
+			set.labels = append(set.labels, f)
+		}
+	}
+	for _, expr := range exclude {
+		f, err := Parse(expr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		set.excludes = append(set.excludes, f)
+	}
+	return set, nil
+}
+
+// Descend returns true if some object below the path may be selected.
+func (set *Set) Descend(parts []string) bool {
+	if set == nil {
+		return true
+	}
+	for _, f := range set.excludes {
+		if f.isPath() && f.MatchPath(parts) {
+			return false
+		}
+	}
+	if len(set.paths) == 0 {
+		return true
+	}
+	for _, f := range set.paths {
+		if f.MayMatch(parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if the object generated in the path is selected.
+func (set *Set) Match(parts []string, obj map[string]any) bool {
+	if set == nil {
+		return true
+	}
+	for _, f := range set.excludes {
+		if f.isPath() && f.MatchPath(parts) || !f.isPath() && f.MatchObject(obj) {
+			return false
+		}
+	}
+	return matchGroup(set.paths, func(f *Filter) bool { return f.MatchPath(parts) }) &&
+		matchGroup(set.kinds, func(f *Filter) bool { return f.MatchObject(obj) }) &&
+		matchGroup(set.labels, func(f *Filter) bool { return f.MatchObject(obj) })
+}
+
+func matchGroup(filters []*Filter, match func(f *Filter) bool) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if match(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated when parsing them.
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// split separates the segments of the path ignoring the dots inside braces.
+func split(expr string) ([]string, error) {
+	var segments []string
+	var depth, start int
+	for i, c := range expr {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, errors.Errorf("unbalanced braces in filter %q", expr)
+			}
+		case '.':
+			if depth == 0 {
+				segments = append(segments, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.Errorf("unbalanced braces in filter %q", expr)
+	}
+	segments = append(segments, expr[start:])
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, errors.Errorf("empty segment in filter %q", expr)
+		}
+	}
+	return segments, nil
+}
+
+// expand returns every alternative of the first brace group of the pattern,
+// recursively.
+func expand(pattern string) ([]string, error) {
+	open := strings.Index(pattern, "{")
+	if open == -1 {
+		if strings.Contains(pattern, "}") {
+			return nil, errors.Errorf("unbalanced braces in filter %q", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Errorf("invalid pattern in filter %q: %s", pattern, err)
+		}
+		return []string{pattern}, nil
+	}
+
+	var depth, end int
+	var options []string
+	start := open + 1
+	for i := open; i < len(pattern) && end == 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				options = append(options, pattern[start:i])
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				options = append(options, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if end == 0 {
+		return nil, errors.Errorf("unbalanced braces in filter %q", pattern)
+	}
+
+	var result []string
+	for _, option := range options {
+		expanded, err := expand(pattern[:open] + option + pattern[end+1:])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		result = append(result, expanded...)
+	}
+	return result, nil
+}
@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"apps.{foo,bar", "unbalanced braces"},
+		{"apps.foo,bar}", "unbalanced braces"},
+		{"apps.{foo.bar", "unbalanced braces"},
+		{"apps.}{", "unbalanced braces"},
+		{"apps..foo", "empty segment"},
+		{".apps", "empty segment"},
+		{"apps.", "empty segment"},
+		{"", "empty segment"},
+		{"apps.[foo", "invalid pattern"},
+		{"kind:{Deployment", "unbalanced braces"},
+		{"kind:[Deployment", "invalid pattern"},
+		{"label:", "missing label name"},
+		{"label:=foo", "missing label name"},
+		{"label:app={foo", "unbalanced braces"},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := Parse(test.expr)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{
+		"apps",
+		"apps.*.deployment",
+		"apps.{foo,bar}.deployment",
+		"apps.{foo.bar,baz}",
+		"apps.{foo,{bar,baz}-x}",
+		"apps.foo-[0-9]",
+		"kind:Deployment",
+		"kind:{Deployment,StatefulSet}",
+		"label:app",
+		"label:app=foo",
+		"label:app={foo,bar}",
+	} {
+		if _, err := Parse(expr); err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", expr, err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	f, err := Parse("apps.{foo,{bar,baz}-x}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"foo", "bar-x", "baz-x"}
+	if strings.Join(f.segments[1], ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected alternatives: %v", f.segments[1])
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		expr     string
+		path     string
+		matchAll bool
+		mayMatch bool
+	}{
+		{"apps", "apps", true, true},
+		{"apps", "apps.foo.deployment", true, true},
+		{"apps", "jobs", false, false},
+		{"apps.*.deployment", "apps", false, true},
+		{"apps.*.deployment", "apps.foo", false, true},
+		{"apps.*.deployment", "apps.foo.deployment", true, true},
+		{"apps.*.deployment", "apps.foo.service", false, false},
+		{"apps.{foo,bar}", "apps.bar", true, true},
+		{"apps.{foo,bar}", "apps.baz", false, false},
+		{"apps.{foo.bar,baz}", "apps.foo.bar", false, false},
+		{"apps.foo-*", "apps.foo-web", true, true},
+
+		// A filter deeper than the path does not select the object generated in it.
+		{"apps.foo.deployment", "apps.foo", false, true},
+	}
+	for _, test := range tests {
+		f, err := Parse(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(test.path, ".")
+		if got := f.MatchPath(parts); got != test.matchAll {
+			t.Errorf("%q.MatchPath(%q) = %v, expected %v", test.expr, test.path, got, test.matchAll)
+		}
+		if got := f.MayMatch(parts); got != test.mayMatch {
+			t.Errorf("%q.MayMatch(%q) = %v, expected %v", test.expr, test.path, got, test.mayMatch)
+		}
+	}
+}
+
+func object(kind string, labels map[string]any) map[string]any {
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name":   "foo",
+			"labels": labels,
+		},
+	}
+}
+
+func TestSetMatch(t *testing.T) {
+	web := object("Deployment", map[string]any{"app": "web", "team": "foo"})
+	worker := object("StatefulSet", map[string]any{"app": "worker"})
+	service := object("Service", map[string]any{"app": "web"})
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		path             string
+		obj              map[string]any
+		expected         bool
+	}{
+		{"no filters", nil, nil, "apps.web", web, true},
+		{"path", []string{"apps.web"}, nil, "apps.web", web, true},
+		{"path mismatch", []string{"apps.web"}, nil, "apps.worker", worker, false},
+		{"any path", []string{"apps.web", "apps.worker"}, nil, "apps.worker", worker, true},
+		{"kind", []string{"kind:Deployment"}, nil, "apps.web", web, true},
+		{"kind mismatch", []string{"kind:Deployment"}, nil, "apps.web", service, false},
+		{"any kind", []string{"kind:Deployment", "kind:Service"}, nil, "apps.web", service, true},
+		{"kind alternatives", []string{"kind:{Deployment,StatefulSet}"}, nil, "apps.worker", worker, true},
+		{"label", []string{"label:team"}, nil, "apps.web", web, true},
+		{"label missing", []string{"label:team"}, nil, "apps.worker", worker, false},
+		{"label value", []string{"label:app=web"}, nil, "apps.web", service, true},
+		{"label value mismatch", []string{"label:app=web"}, nil, "apps.worker", worker, false},
+		{"label glob", []string{"label:app=w*"}, nil, "apps.worker", worker, true},
+		{"path and kind", []string{"apps.*", "kind:Service"}, nil, "apps.web", service, true},
+		{"path and kind mismatch", []string{"apps.*", "kind:Service"}, nil, "apps.web", web, false},
+		{"path, kind and label", []string{"apps.*", "kind:Deployment", "label:team=foo"}, nil, "apps.web", web, true},
+		{"path, kind and label mismatch", []string{"jobs.*", "kind:Deployment", "label:team=foo"}, nil, "apps.web", web, false},
+		{"exclude path", nil, []string{"apps.web"}, "apps.web", web, false},
+		{"exclude parent path", nil, []string{"apps"}, "apps.web", web, false},
+		{"exclude other path", nil, []string{"apps.worker"}, "apps.web", web, true},
+		{"exclude kind", nil, []string{"kind:Service"}, "apps.web", service, false},
+		{"exclude label", []string{"apps.*"}, []string{"label:app=worker"}, "apps.worker", worker, false},
+		{"exclude wins", []string{"kind:Deployment"}, []string{"apps.web"}, "apps.web", web, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := NewSet(test.include, test.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := set.Match(strings.Split(test.path, "."), test.obj); got != test.expected {
+				t.Errorf("Match(%q) = %v, expected %v", test.path, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSetDescend(t *testing.T) {
+	set, err := NewSet([]string{"apps.*.deployment", "kind:Service"}, []string{"apps.worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"apps", true},
+		{"apps.web", true},
+		{"apps.worker", false},
+		{"apps.worker.deployment", false},
+		{"jobs", false},
+	}
+	for _, test := range tests {
+		if got := set.Descend(strings.Split(test.path, ".")); got != test.expected {
+			t.Errorf("Descend(%q) = %v, expected %v", test.path, got, test.expected)
+		}
+	}
+
+	var empty *Set
+	if !empty.Descend([]string{"apps"}) || !empty.Match([]string{"apps"}, nil) {
+		t.Error("a nil set should select everything")
+	}
+}
@@ -51,13 +51,16 @@ func BotUsername() string {
 	return os.Getenv("GERRIT_BOT_USERNAME")
 }
 
+// shellEscaper protects the message from the remote shell that runs the Gerrit command.
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
 func Comment(msg string) error {
 	ssh := []string{
 		"ssh",
 		"-p", Port(),
 		fmt.Sprintf("%s@%s", BotUsername(), Host()),
 		"gerrit", "review", fmt.Sprintf("%v,%v", ChangeNumber(), PatchSet()),
-		"--message", `"` + msg + `"`,
+		"--message", `"` + shellEscaper.Replace(msg) + `"`,
 	}
 	slog.Debug(strings.Join(ssh, " "))
 	comment := exec.Command(ssh[0], ssh[1:]...)
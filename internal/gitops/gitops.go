@@ -0,0 +1,86 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+)
+
+type PublishOptions struct {
+	// Path inside the repository with the published files.
+	Path string
+
+	// Branch where the commit is pushed.
+	Branch string
+
+	Message string
+}
+
+// Publish commits the changes of the path in the local clone of the repository and
+// pushes them. It returns false without committing anything if the files did
+// not change.
+func Publish(ctx context.Context, repo string, opts PublishOptions) (bool, error) {
+	if opts.Branch == "" {
+		return false, errors.Errorf("missing branch to push the commit")
+	}
+	if err := git(ctx, repo, nil, "add", "--all", "--", opts.Path); err != nil {
+		return false, errors.Trace(err)
+	}
+	if err := git(ctx, repo, nil, "diff", "--cached", "--quiet", "--", opts.Path); err == nil {
+		return false, nil
+	} else if exit := new(exec.ExitError); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		return false, errors.Trace(err)
+	}
+
+	if err := git(ctx, repo, strings.NewReader(opts.Message), "commit", "--quiet", "--file", "-", "--", opts.Path); err != nil {
+		return false, errors.Trace(err)
+	}
+	if err := git(ctx, repo, nil, "push", "--quiet", "origin", "HEAD:refs/heads/"+opts.Branch); err != nil {
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// CurrentBranch returns the branch checked out in the repository. It returns an
+// empty string if the repository is in a detached HEAD.
+func CurrentBranch(ctx context.Context, repo string) (string, error) {
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "symbolic-ref", "--quiet", "--short", "HEAD")
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exit := new(exec.ExitError); errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", errors.Trace(err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Head returns the hash of the last commit of the repository.
+func Head(ctx context.Context, repo string) (string, error) {
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "rev-parse", "HEAD")
+	cmd.Stdout = &buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func git(ctx context.Context, repo string, stdin io.Reader, args ...string) error {
+	args = append([]string{"-C", repo}, args...)
+	slog.Debug("git " + strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return errors.Trace(cmd.Run())
+}
@@ -0,0 +1,503 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/altipla-consulting/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultNamespace is used as .Release.Namespace if none is configured, the same
+// default of helm template.
+const DefaultNamespace = "default"
+
+type Options struct {
+	// ReleaseName defaults to the name of the chart.
+	ReleaseName string
+
+	// Namespace of the release. Defaults to DefaultNamespace.
+	Namespace string
+
+	// KubeVersion is reported to the charts in .Capabilities with the format 1.34.
+	KubeVersion string
+
+	// APIVersions lists the versions reported by .Capabilities.APIVersions.Has,
+	// with the formats apps/v1 and apps/v1/Deployment.
+	APIVersions []string
+}
+
+// Template renders a local chart directory without contacting any cluster and
+// returns every object of the output. It is a small reimplementation of the Helm
+// engine that supports the most common features: values, named templates, the
+// sprig functions, subcharts unpacked in the charts folder, global values passed
+// down to the subcharts and the crds folder. It differs from helm template in:
+//
+//   - Packaged .tgz dependencies fail, unpack them in the charts folder.
+//   - .Files.Glob, .Files.AsConfig and .Files.AsSecrets fail.
+//   - .Files.Get only reads files inside the chart directory and ignores .helmignore.
+//   - .Capabilities.HelmVersion is empty.
+//   - lookup always returns an empty object.
+//   - Objects are returned with the CRDs first and then sorted by template name,
+//     not in the install order of Helm.
+//   - Values of the subcharts are not validated with values.schema.json.
+func Template(dir string, values map[string]any, opts Options) ([]map[string]any, error) {
+	c, err := load(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.ReleaseName == "" {
+		opts.ReleaseName = c.metadata.Name
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if opts.KubeVersion == "" {
+		return nil, errors.Errorf("missing Kubernetes version to render the chart")
+	}
+
+	r := &renderer{
+		tmpl: template.New("gotpl"),
+		opts: opts,
+	}
+	r.tmpl.Option("missingkey=zero")
+	r.tmpl.Funcs(r.funcMap())
+	if err := r.add(c, coalesce(c.values, values), c.metadata.Name, nil); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return r.render()
+}
+
+type metadata struct {
+	APIVersion   string       `json:"apiVersion"`
+	Name         string       `json:"name"`
+	Version      string       `json:"version"`
+	AppVersion   string       `json:"appVersion"`
+	Description  string       `json:"description"`
+	Type         string       `json:"type"`
+	Dependencies []dependency `json:"dependencies"`
+}
+
+type dependency struct {
+	Name      string `json:"name"`
+	Alias     string `json:"alias"`
+	Condition string `json:"condition"`
+}
+
+type chart struct {
+	dir      string
+	metadata metadata
+	values   map[string]any
+}
+
+func load(dir string) (*chart, error) {
+	c := &chart{dir: dir}
+	content, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(content, &c.metadata); err != nil {
+		return nil, errors.Errorf("cannot read %s/Chart.yaml: %s", dir, err)
+	}
+	if c.metadata.Name == "" {
+		return nil, errors.Errorf("missing chart name in %s/Chart.yaml", dir)
+	}
+
+	c.values = make(map[string]any)
+	content, err = os.ReadFile(filepath.Join(dir, "values.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	if err := yaml.Unmarshal(content, &c.values); err != nil {
+		return nil, errors.Errorf("cannot read %s/values.yaml: %s", dir, err)
+	}
+	if c.values == nil {
+		c.values = make(map[string]any)
+	}
+	return c, nil
+}
+
+type file struct {
+	name      string
+	chart     *chart
+	values    map[string]any
+	chartPath []string
+}
+
+type renderer struct {
+	tmpl  *template.Template
+	opts  Options
+	files []*file
+	crds  [][]byte
+}
+
+// add parses the templates of the chart and its subcharts.
+func (r *renderer) add(c *chart, values map[string]any, name string, parents []string) error {
+	chartPath := append(append([]string{}, parents...), name)
+
+	crds, err := filepath.Glob(filepath.Join(c.dir, "crds", "*.yaml"))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, crd := range crds {
+		content, err := os.ReadFile(crd)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		r.crds = append(r.crds, content)
+	}
+
+	templatesDir := filepath.Join(c.dir, "templates")
+	err = filepath.WalkDir(templatesDir, func(filename string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filename == templatesDir {
+				return nil
+			}
+			return errors.Trace(err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := filepath.Ext(filename)
+		if ext != ".yaml" && ext != ".yml" && ext != ".tpl" {
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, filename)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tmplName := path.Join(strings.Join(chartPath, "/charts/"), filepath.ToSlash(rel))
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err := r.tmpl.New(tmplName).Parse(string(content)); err != nil {
+			return errors.Trace(err)
+		}
+		if !strings.HasPrefix(filepath.Base(filename), "_") && ext != ".tpl" {
+			r.files = append(r.files, &file{
+				name:      tmplName,
+				chart:     c,
+				values:    values,
+				chartPath: chartPath,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	subcharts, err := os.ReadDir(filepath.Join(c.dir, "charts"))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	for _, entry := range subcharts {
+		if !entry.IsDir() {
+			return errors.Errorf("packaged dependency %s is not supported, unpack it in %s/charts", entry.Name(), c.dir)
+		}
+		sub, err := load(filepath.Join(c.dir, "charts", entry.Name()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		name := sub.metadata.Name
+		var condition string
+		for _, dep := range c.metadata.Dependencies {
+			if dep.Name == name {
+				if dep.Alias != "" {
+					name = dep.Alias
+				}
+				condition = dep.Condition
+			}
+		}
+		if condition != "" && !enabled(values, condition) {
+			continue
+		}
+
+		subValues, _ := values[name].(map[string]any)
+		subValues = coalesce(sub.values, subValues)
+		if global, ok := values["global"].(map[string]any); ok {
+			subGlobal, _ := subValues["global"].(map[string]any)
+			subValues["global"] = coalesce(subGlobal, global)
+		}
+		if err := r.add(sub, subValues, name, chartPath); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+var reDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+func (r *renderer) render() ([]map[string]any, error) {
+	var objects []map[string]any
+	for _, crd := range r.crds {
+		docs, err := parseDocuments("crds", crd)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		objects = append(objects, docs...)
+	}
+
+	sort.Slice(r.files, func(i, j int) bool {
+		return r.files[i].name < r.files[j].name
+	})
+	for _, f := range r.files {
+		var buf bytes.Buffer
+		if err := r.tmpl.ExecuteTemplate(&buf, f.name, r.scope(f)); err != nil {
+			return nil, errors.Trace(err)
+		}
+		output := strings.ReplaceAll(buf.String(), "<no value>", "")
+		docs, err := parseDocuments(f.name, []byte(output))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		objects = append(objects, docs...)
+	}
+	return objects, nil
+}
+
+func parseDocuments(name string, content []byte) ([]map[string]any, error) {
+	var objects []map[string]any
+	for _, doc := range reDocumentSeparator.Split(string(content), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var obj map[string]any
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, errors.Errorf("cannot read the output of %s: %s", name, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func (r *renderer) scope(f *file) map[string]any {
+	major, minor, _ := strings.Cut(r.opts.KubeVersion, ".")
+	kubeVersion := "v" + r.opts.KubeVersion + ".0"
+	return map[string]any{
+		"Values": f.values,
+		"Release": map[string]any{
+			"Name":      r.opts.ReleaseName,
+			"Namespace": r.opts.Namespace,
+			"Service":   "Helm",
+			"IsInstall": true,
+			"IsUpgrade": false,
+			"Revision":  1,
+		},
+		"Chart": map[string]any{
+			"Name":        f.chart.metadata.Name,
+			"Version":     f.chart.metadata.Version,
+			"AppVersion":  f.chart.metadata.AppVersion,
+			"Description": f.chart.metadata.Description,
+			"Type":        f.chart.metadata.Type,
+			"APIVersion":  f.chart.metadata.APIVersion,
+		},
+		"Capabilities": map[string]any{
+			"KubeVersion": map[string]any{
+				"Version":    kubeVersion,
+				"GitVersion": kubeVersion,
+				"Major":      major,
+				"Minor":      minor,
+			},
+			"APIVersions": apiVersions(r.opts.APIVersions),
+		},
+		"Template": map[string]any{
+			"Name":     f.name,
+			"BasePath": path.Join(strings.Join(f.chartPath, "/charts/"), "templates"),
+		},
+		"Files": files{dir: f.chart.dir},
+	}
+}
+
+// apiVersions reports the APIs available in the Kubernetes version of the options
+// because the cluster is not queried.
+type apiVersions []string
+
+func (versions apiVersions) Has(version string) bool {
+	return slices.Contains(versions, version)
+}
+
+type files struct {
+	dir string
+}
+
+// Get returns the content of the file of the chart, or empty if it does not exist
+// or it is outside the chart directory.
+func (f files) Get(name string) string {
+	rel := path.Clean("/" + filepath.ToSlash(name))[1:]
+	if rel == "" || rel != strings.TrimPrefix(filepath.ToSlash(name), "./") {
+		return ""
+	}
+	root, err := filepath.EvalSymlinks(f.dir)
+	if err != nil {
+		return ""
+	}
+	filename, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return ""
+	}
+	if inside, err := filepath.Rel(root, filename); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func (f files) GetBytes(name string) []byte {
+	return []byte(f.Get(name))
+}
+
+// Glob and the helpers of its result are not supported. Failing is better than
+// silently rendering empty ConfigMaps and Secrets.
+func (f files) Glob(pattern string) (files, error) {
+	return f, errors.Errorf(".Files.Glob %q is not supported, read the files one by one with .Files.Get", pattern)
+}
+
+func (f files) AsConfig() (string, error) {
+	return "", errors.Errorf(".Files.AsConfig is not supported")
+}
+
+func (f files) AsSecrets() (string, error) {
+	return "", errors.Errorf(".Files.AsSecrets is not supported")
+}
+
+func (r *renderer) funcMap() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	funcs["toYaml"] = func(v any) string {
+		content, err := yaml.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSuffix(string(content), "\n")
+	}
+	funcs["fromYaml"] = func(s string) map[string]any {
+		m := make(map[string]any)
+		if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+			m["Error"] = err.Error()
+		}
+		return m
+	}
+	funcs["fromYamlArray"] = func(s string) []any {
+		var a []any
+		if err := yaml.Unmarshal([]byte(s), &a); err != nil {
+			a = []any{err.Error()}
+		}
+		return a
+	}
+	funcs["toJson"] = func(v any) string {
+		content, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(content)
+	}
+	funcs["fromJson"] = func(s string) map[string]any {
+		m := make(map[string]any)
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			m["Error"] = err.Error()
+		}
+		return m
+	}
+	funcs["fromJsonArray"] = func(s string) []any {
+		var a []any
+		if err := json.Unmarshal([]byte(s), &a); err != nil {
+			a = []any{err.Error()}
+		}
+		return a
+	}
+	funcs["include"] = func(name string, data any) (string, error) {
+		var buf bytes.Buffer
+		if err := r.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	funcs["tpl"] = func(text string, data any) (string, error) {
+		t, err := r.tmpl.Clone()
+		if err != nil {
+			return "", err
+		}
+		t, err = t.New("tpl").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.ReplaceAll(buf.String(), "<no value>", ""), nil
+	}
+	funcs["required"] = func(msg string, v any) (any, error) {
+		if v == nil || v == "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return v, nil
+	}
+	funcs["lookup"] = func(apiVersion, kind, namespace, name string) (map[string]any, error) {
+		return map[string]any{}, nil
+	}
+	return funcs
+}
+
+// coalesce merges the values over the defaults of the chart. Null values remove
+// the default, as Helm does.
+func coalesce(defaults, values map[string]any) map[string]any {
+	result := make(map[string]any)
+	for k, v := range defaults {
+		result[k] = v
+	}
+	for k, v := range values {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		dst, ok := result[k].(map[string]any)
+		src, isMap := v.(map[string]any)
+		if ok && isMap {
+			result[k] = coalesce(dst, src)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// enabled evaluates the condition of a dependency, for example redis.enabled.
+// Missing values enable the dependency.
+func enabled(values map[string]any, condition string) bool {
+	for _, cond := range strings.Split(condition, ",") {
+		var current any = values
+		for _, part := range strings.Split(strings.TrimSpace(cond), ".") {
+			m, ok := current.(map[string]any)
+			if !ok {
+				current = nil
+				break
+			}
+			current = m[part]
+		}
+		if b, ok := current.(bool); ok {
+			return b
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package helm
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "Update the golden files of the tests.")
+
+func TestTemplateGolden(t *testing.T) {
+	objects, err := Template(filepath.Join("testdata", "web"), map[string]any{"replicas": 3}, Options{
+		ReleaseName: "shop",
+		Namespace:   "prod",
+		KubeVersion: "1.34",
+		APIVersions: []string{"v1", "apps/v1", "policy/v1", "policy/v1/PodDisruptionBudget"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := yaml.Marshal(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "web.golden.yaml")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("output does not match %s, run the tests with -update to refresh it:\n%s", golden, got)
+	}
+}
+
+func TestTemplateDisabledSubchart(t *testing.T) {
+	objects, err := Template(filepath.Join("testdata", "web"), map[string]any{"cache": map[string]any{"enabled": false}}, Options{
+		KubeVersion: "1.34",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj["kind"] == "Service" {
+			t.Errorf("the disabled subchart should not be rendered")
+		}
+		if obj["kind"] == "PodDisruptionBudget" {
+			t.Errorf("APIs that are not available should not be rendered")
+		}
+		metadata := obj["metadata"].(map[string]any)
+		if metadata["namespace"] != DefaultNamespace {
+			t.Errorf("unexpected namespace: %v", metadata["namespace"])
+		}
+	}
+}
+
+func TestTemplateFilesGlob(t *testing.T) {
+	_, err := Template(filepath.Join("testdata", "glob"), nil, Options{KubeVersion: "1.34"})
+	if err == nil || !strings.Contains(err.Error(), ".Files.Glob") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFilesGetOutsideChart(t *testing.T) {
+	dir := t.TempDir()
+	chartDir := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(filepath.Join(chartDir, "files"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(chartDir, "files", "app.conf"), []byte("inside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("outside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(chartDir, "files", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	f := files{dir: chartDir}
+	if got := f.Get("files/app.conf"); got != "inside" {
+		t.Errorf("unexpected content: %q", got)
+	}
+	for _, name := range []string{
+		"../secret.txt",
+		"files/../../secret.txt",
+		filepath.Join(dir, "secret.txt"),
+		"files/link.txt",
+		"",
+	} {
+		if got := f.Get(name); got != "" {
+			t.Errorf("Get(%q) should not read outside the chart: %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// FieldManager is the name used to own the fields applied with server-side apply.
+const FieldManager = "wave"
+
+// ApplyResult is the outcome of applying a single object.
+type ApplyResult struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+
+	// Operation is "created" or "configured" when the object was applied successfully.
+	Operation string
+
+	Err error
+}
+
+func (result *ApplyResult) String() string {
+	name := result.Name
+	if result.Namespace != "" {
+		name = result.Namespace + "/" + name
+	}
+	if result.Err != nil {
+		return fmt.Sprintf("%s %s failed: %s", strings.ToLower(result.Kind), name, result.Err)
+	}
+	return fmt.Sprintf("%s %s %s", strings.ToLower(result.Kind), name, result.Operation)
+}
+
+// applyOrder lists the kinds that must exist before others can be applied. Any
+// other kind, like the workloads, is applied afterwards.
+var applyOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+	"ServiceAccount":           2,
+	"ClusterRole":              3,
+	"Role":                     3,
+	"ClusterRoleBinding":       4,
+	"RoleBinding":              4,
+	"ConfigMap":                5,
+	"Secret":                   5,
+	"PersistentVolumeClaim":    5,
+	"Service":                  6,
+}
+
+const defaultApplyOrder = 7
+
+// SortForApply orders the objects in the dependency order needed to apply them.
+// Objects of the same group keep their relative order.
+func SortForApply(objects []map[string]any) []map[string]any {
+	sorted := make([]map[string]any, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return kindOrder(sorted[i]) < kindOrder(sorted[j])
+	})
+	return sorted
+}
+
+func kindOrder(obj map[string]any) int {
+	kind, _ := obj["kind"].(string)
+	if order, ok := applyOrder[kind]; ok {
+		return order
+	}
+	return defaultApplyOrder
+}
+
+// ApplyAll applies every object with server-side apply in dependency order. It
+// continues after failures and returns the result of each object.
+func (client *Client) ApplyAll(ctx context.Context, objects []map[string]any) ([]*ApplyResult, error) {
+	var results []*ApplyResult
+	var failed int
+	for _, obj := range SortForApply(objects) {
+		result := client.Apply(ctx, obj)
+		if result.Err != nil {
+			failed++
+		}
+		results = append(results, result)
+	}
+	if failed > 0 {
+		return results, errors.Errorf("%d objects failed to apply", failed)
+	}
+	return results, nil
+}
+
+// Apply sends a single object with server-side apply, taking the ownership of
+// conflicting fields.
+func (client *Client) Apply(ctx context.Context, obj map[string]any) *ApplyResult {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	result := &ApplyResult{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       name,
+	}
+
+	resource, err := client.resourceWithRetry(ctx, apiVersion, kind)
+	if err != nil {
+		result.Err = errors.Trace(err)
+		return result
+	}
+
+	if resource.Namespaced {
+		if namespace == "" {
+			namespace = client.Namespace
+		}
+		result.Namespace = namespace
+	}
+	path := resourcePath(apiVersion, resource, namespace, name)
+
+	query := url.Values{
+		"fieldManager": {FieldManager},
+		"force":        {"true"},
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		result.Err = errors.Trace(err)
+		return result
+	}
+	code, err := client.do(ctx, http.MethodPatch, path+"?"+query.Encode(), "application/apply-patch+yaml", body, nil)
+	if err != nil {
+		result.Err = errors.Trace(err)
+		return result
+	}
+
+	result.Operation = "configured"
+	if code == http.StatusCreated {
+		result.Operation = "created"
+	}
+	return result
+}
+
+// resourceWithRetry gives some time to the API server to serve the kinds of
+// recently applied CRDs.
+func (client *Client) resourceWithRetry(ctx context.Context, apiVersion, kind string) (*APIResource, error) {
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		var resource *APIResource
+		resource, err = client.Resource(ctx, apiVersion, kind)
+		if err == nil {
+			return resource, nil
+		}
+		if !IsNotFound(err) {
+			return nil, errors.Trace(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+	return nil, errors.Trace(err)
+}
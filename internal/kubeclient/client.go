@@ -0,0 +1,392 @@
+package kubeclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// Client talks directly to the Kubernetes API server without requiring kubectl.
+type Client struct {
+	// Server is the base URL of the API server.
+	Server string
+
+	// Namespace is used for namespaced objects that do not declare one.
+	Namespace string
+
+	HTTPClient *http.Client
+
+	token              string
+	username, password string
+
+	mu        sync.Mutex
+	discovery map[string][]APIResource
+}
+
+// APIResource describes a kind served by the API server.
+type APIResource struct {
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"`
+	Namespaced bool     `json:"namespaced"`
+	Verbs      []string `json:"verbs"`
+}
+
+// Supports returns true if the resource accepts every verb.
+func (resource *APIResource) Supports(verbs ...string) bool {
+	for _, verb := range verbs {
+		if !slices.Contains(resource.Verbs, verb) {
+			return false
+		}
+	}
+	return true
+}
+
+// New builds a client for the context with that name of the kubeconfig, or the
+// current context if empty.
+func New(ctxName string) (*Client, error) {
+	config, err := LoadKubeconfig()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	kctx, cluster, user, err := config.Resolve(ctxName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+		ServerName:         cluster.TLSServerName,
+	}
+	ca, err := readData(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("invalid certificate authority for cluster %q", kctx.Cluster)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	client := &Client{
+		Server:    strings.TrimSuffix(cluster.Server, "/"),
+		Namespace: kctx.Namespace,
+	}
+	if client.Namespace == "" {
+		client.Namespace = "default"
+	}
+
+	cert, err := readData(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	key, err := readData(user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	client.token = user.Token
+	if user.TokenFile != "" {
+		token, err := os.ReadFile(user.TokenFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		client.token = strings.TrimSpace(string(token))
+	}
+	client.username, client.password = user.Username, user.Password
+	if user.Exec != nil {
+		cred, err := runExecPlugin(user.Exec)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if cred.Token != "" {
+			client.token = cred.Token
+		}
+		if cred.ClientCertificateData != "" {
+			cert, key = []byte(cred.ClientCertificateData), []byte(cred.ClientKeyData)
+		}
+	}
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	client.HTTPClient = &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return client, nil
+}
+
+// NewForServer builds a client for an unauthenticated server. Useful to run against
+// a fake API server.
+func NewForServer(server, namespace string, httpClient *http.Client) *Client {
+	return &Client{
+		Server:     strings.TrimSuffix(server, "/"),
+		Namespace:  namespace,
+		HTTPClient: httpClient,
+	}
+}
+
+func readData(data, filename string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		return decoded, errors.Trace(err)
+	}
+	if filename != "" {
+		content, err := os.ReadFile(filename)
+		return content, errors.Trace(err)
+	}
+	return nil, nil
+}
+
+type execCredentialStatus struct {
+	Token                 string `json:"token"`
+	ClientCertificateData string `json:"clientCertificateData"`
+	ClientKeyData         string `json:"clientKeyData"`
+}
+
+func runExecPlugin(config *ExecConfig) (*execCredentialStatus, error) {
+	info, err := json.Marshal(map[string]any{
+		"apiVersion": config.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]any{"interactive": false},
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(info))
+	for _, env := range config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var cred struct {
+		Status *execCredentialStatus `json:"status"`
+	}
+	if err := json.Unmarshal(output, &cred); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cred.Status == nil {
+		return nil, errors.Errorf("credential plugin %s did not return a status", config.Command)
+	}
+	return cred.Status, nil
+}
+
+// StatusError is returned when the API server rejects a request.
+type StatusError struct {
+	Code    int
+	Reason  string
+	Message string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("kubernetes: %s (status %d): %s", err.Reason, err.Code, err.Message)
+}
+
+// IsNotFound reports if the error is a 404 of the API server.
+func IsNotFound(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && status.Code == http.StatusNotFound
+}
+
+// do sends the request and decodes the reply in dest if not nil. It returns the
+// status code of successful replies.
+func (client *Client) do(ctx context.Context, method, path, contentType string, body []byte, dest any) (int, error) {
+	code, reply, err := client.send(ctx, method, path, contentType, body)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if dest != nil {
+		if err := json.Unmarshal(reply, dest); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return code, nil
+}
+
+// send sends the request and returns the status code and the raw body of
+// successful replies.
+func (client *Client) send(ctx context.Context, method, path, contentType string, body []byte) (int, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, client.Server+path, r)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	// The logs are plain text.
+	req.Header.Set("Accept", "application/json, */*")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case client.token != "":
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	case client.username != "":
+		req.SetBasicAuth(client.username, client.password)
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var status struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(reply, &status)
+		if status.Message == "" {
+			status.Message = strings.TrimSpace(string(reply))
+		}
+		if status.Reason == "" {
+			status.Reason = http.StatusText(resp.StatusCode)
+		}
+		return 0, nil, errors.Trace(&StatusError{
+			Code:    resp.StatusCode,
+			Reason:  status.Reason,
+			Message: status.Message,
+		})
+	}
+	return resp.StatusCode, reply, nil
+}
+
+// Resource resolves the kind of an apiVersion through the discovery API.
+func (client *Client) Resource(ctx context.Context, apiVersion, kind string) (*APIResource, error) {
+	resources, err := client.Resources(ctx, apiVersion)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, r := range resources {
+		if r.Kind == kind {
+			return &r, nil
+		}
+	}
+
+	// Forget the cached list in case the kind is being registered right now.
+	client.mu.Lock()
+	delete(client.discovery, apiVersion)
+	client.mu.Unlock()
+	return nil, errors.Trace(&StatusError{
+		Code:    http.StatusNotFound,
+		Reason:  "NotFound",
+		Message: fmt.Sprintf("kind %s not found in %s", kind, apiVersion),
+	})
+}
+
+// Resources returns the kinds served in an apiVersion through the discovery API.
+func (client *Client) Resources(ctx context.Context, apiVersion string) ([]APIResource, error) {
+	client.mu.Lock()
+	resources, ok := client.discovery[apiVersion]
+	client.mu.Unlock()
+	if ok {
+		return resources, nil
+	}
+
+	var list struct {
+		Resources []APIResource `json:"resources"`
+	}
+	if _, err := client.do(ctx, http.MethodGet, apiPath(apiVersion), "", nil, &list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, r := range list.Resources {
+		// Ignore subresources like deployments/status.
+		if !strings.Contains(r.Name, "/") {
+			resources = append(resources, r)
+		}
+	}
+
+	client.mu.Lock()
+	if client.discovery == nil {
+		client.discovery = make(map[string][]APIResource)
+	}
+	client.discovery[apiVersion] = resources
+	client.mu.Unlock()
+	return resources, nil
+}
+
+// APIVersions returns the preferred version of every API group served by the
+// cluster, including the core group.
+func (client *Client) APIVersions(ctx context.Context) ([]string, error) {
+	var core struct {
+		Versions []string `json:"versions"`
+	}
+	if _, err := client.do(ctx, http.MethodGet, "/api", "", nil, &core); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var groups struct {
+		Groups []struct {
+			PreferredVersion struct {
+				GroupVersion string `json:"groupVersion"`
+			} `json:"preferredVersion"`
+		} `json:"groups"`
+	}
+	if _, err := client.do(ctx, http.MethodGet, "/apis", "", nil, &groups); err != nil {
+		return nil, errors.Trace(err)
+	}
+	versions := core.Versions
+	for _, group := range groups.Groups {
+		versions = append(versions, group.PreferredVersion.GroupVersion)
+	}
+	return versions, nil
+}
+
+func apiPath(apiVersion string) string {
+	if !strings.Contains(apiVersion, "/") {
+		return "/api/" + apiVersion
+	}
+	return "/apis/" + apiVersion
+}
+
+// resourcePath returns the path of the object, or the collection of objects if
+// the name is empty. Namespaced resources without namespace return the
+// collection of every namespace.
+func resourcePath(apiVersion string, resource *APIResource, namespace, name string) string {
+	path := apiPath(apiVersion)
+	if resource.Namespaced && namespace != "" {
+		path += "/namespaces/" + url.PathEscape(namespace)
+	}
+	path += "/" + resource.Name
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
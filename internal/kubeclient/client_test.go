@@ -0,0 +1,329 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a minimal API server that serves the discovery and stores the
+// applied objects in memory.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string]map[string]any
+	patches []string
+}
+
+var fakeDiscovery = map[string]string{
+	"/api":  `{"versions": ["v1"]}`,
+	"/apis": `{"groups": [{"name": "apps", "preferredVersion": {"groupVersion": "apps/v1"}}]}`,
+	"/api/v1": `{"resources": [
+		{"name": "namespaces", "kind": "Namespace", "namespaced": false, "verbs": ["get", "list", "delete", "patch"]},
+		{"name": "configmaps", "kind": "ConfigMap", "namespaced": true, "verbs": ["get", "list", "delete", "patch"]},
+		{"name": "pods", "kind": "Pod", "namespaced": true, "verbs": ["get", "list", "delete"]},
+		{"name": "pods/log", "kind": "Pod", "namespaced": true, "verbs": ["get"]}
+	]}`,
+	"/apis/apps/v1": `{"resources": [
+		{"name": "deployments", "kind": "Deployment", "namespaced": true, "verbs": ["get", "list", "delete", "patch"]},
+		{"name": "deployments/status", "kind": "Deployment", "namespaced": true, "verbs": ["get", "patch"]}
+	]}`,
+}
+
+var fakeCollections = map[string]bool{
+	"namespaces":  true,
+	"configmaps":  true,
+	"pods":        true,
+	"deployments": true,
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *Client) {
+	fake := &fakeServer{objects: make(map[string]map[string]any)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, NewForServer(server.URL, "default", server.Client())
+}
+
+func (fake *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if reply, ok := fakeDiscovery[r.URL.Path]; ok {
+		w.Write([]byte(reply))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || r.URL.Query().Get("fieldManager") != FieldManager {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var obj map[string]any
+		if err := json.Unmarshal(body, &obj); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fake.patches = append(fake.patches, r.URL.Path)
+		_, exists := fake.objects[r.URL.Path]
+		fake.objects[r.URL.Path] = obj
+		if !exists {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write(body)
+
+	case http.MethodGet:
+		if obj, ok := fake.objects[r.URL.Path]; ok {
+			json.NewEncoder(w).Encode(obj)
+			return
+		}
+		if !fakeCollections[lastSegment(r.URL.Path)] {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind": "Status", "reason": "NotFound", "message": "not found"}`))
+			return
+		}
+		selector := r.URL.Query().Get("labelSelector")
+		var items []map[string]any
+		for path, obj := range fake.objects {
+			collection := path[:strings.LastIndex(path, "/")]
+			if !strings.HasSuffix(collection, "/"+lastSegment(r.URL.Path)) {
+				continue
+			}
+			metadata, _ := obj["metadata"].(map[string]any)
+			labels, _ := metadata["labels"].(map[string]any)
+			if key, value, ok := strings.Cut(selector, "="); ok && labels[key] != value {
+				continue
+			}
+			// Lists do not repeat the type of the items.
+			item := make(map[string]any)
+			for k, v := range obj {
+				if k != "apiVersion" && k != "kind" {
+					item[k] = v
+				}
+			}
+			items = append(items, item)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+
+	case http.MethodDelete:
+		if _, ok := fake.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fake.objects, r.URL.Path)
+		w.Write([]byte(`{"kind": "Status", "status": "Success"}`))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func testObject(apiVersion, kind, namespace, name string) map[string]any {
+	metadata := map[string]any{
+		"name":   name,
+		"labels": map[string]any{"inventory": "app"},
+	}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   metadata,
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	_, client := newFakeServer(t)
+
+	resource, err := client.Resource(context.Background(), "apps/v1", "Deployment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resource.Name != "deployments" || !resource.Namespaced {
+		t.Errorf("unexpected resource: %+v", resource)
+	}
+
+	if _, err := client.Resource(context.Background(), "apps/v1", "Unknown"); !IsNotFound(err) {
+		t.Errorf("unknown kind should not be found: %v", err)
+	}
+
+	versions, err := client.APIVersions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(versions, ",") != "v1,apps/v1" {
+		t.Errorf("unexpected versions: %v", versions)
+	}
+}
+
+func TestApplyAll(t *testing.T) {
+	fake, client := newFakeServer(t)
+
+	objects := []map[string]any{
+		testObject("apps/v1", "Deployment", "", "web"),
+		testObject("v1", "ConfigMap", "prod", "settings"),
+		testObject("v1", "Namespace", "", "prod"),
+	}
+	results, err := client.ApplyAll(context.Background(), objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, result := range results {
+		lines = append(lines, result.String())
+	}
+	want := []string{
+		"namespace prod created",
+		"configmap prod/settings created",
+		"deployment default/web created",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected results:\n%s", strings.Join(lines, "\n"))
+	}
+	wantPatches := []string{
+		"/api/v1/namespaces/prod",
+		"/api/v1/namespaces/prod/configmaps/settings",
+		"/apis/apps/v1/namespaces/default/deployments/web",
+	}
+	if strings.Join(fake.patches, "\n") != strings.Join(wantPatches, "\n") {
+		t.Errorf("unexpected paths:\n%s", strings.Join(fake.patches, "\n"))
+	}
+
+	results, err = client.ApplyAll(context.Background(), objects[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Operation != "configured" {
+		t.Errorf("second apply should configure the object: %s", results[0])
+	}
+}
+
+func TestApplyAllUnknownKind(t *testing.T) {
+	_, client := newFakeServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results, err := client.ApplyAll(ctx, []map[string]any{
+		testObject("v1", "ConfigMap", "", "settings"),
+		testObject("example.com/v1", "Widget", "", "foo"),
+	})
+	if err == nil {
+		t.Fatal("unknown kind should fail")
+	}
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Errorf("unexpected results: %s, %s", results[0], results[1])
+	}
+}
+
+func TestListLabeledAndDelete(t *testing.T) {
+	_, client := newFakeServer(t)
+	ctx := context.Background()
+
+	other := testObject("v1", "ConfigMap", "", "other")
+	other["metadata"].(map[string]any)["labels"] = map[string]any{"inventory": "other"}
+	if _, err := client.ApplyAll(ctx, []map[string]any{
+		testObject("v1", "ConfigMap", "", "settings"),
+		testObject("apps/v1", "Deployment", "", "web"),
+		other,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := client.ListLabeled(ctx, "inventory=app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj["kind"].(string)+"/"+obj["metadata"].(map[string]any)["name"].(string))
+	}
+	if strings.Join(names, ",") != "ConfigMap/settings,Deployment/web" {
+		t.Errorf("unexpected objects: %v", names)
+	}
+
+	for _, obj := range objects {
+		if err := client.Delete(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Get(ctx, "v1", "ConfigMap", "default", "settings"); !IsNotFound(err) {
+		t.Errorf("the object should be deleted: %v", err)
+	}
+}
+
+func TestDeploymentStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status map[string]any
+		done   bool
+	}{
+		{"not observed", map[string]any{"observedGeneration": 1.0}, false},
+		{"updating", map[string]any{"observedGeneration": 2.0, "replicas": 3.0, "updatedReplicas": 1.0}, false},
+		{"terminating", map[string]any{"observedGeneration": 2.0, "replicas": 4.0, "updatedReplicas": 3.0, "availableReplicas": 3.0}, false},
+		{"unavailable", map[string]any{"observedGeneration": 2.0, "replicas": 3.0, "updatedReplicas": 3.0, "availableReplicas": 2.0}, false},
+		{"finished", map[string]any{"observedGeneration": 2.0, "replicas": 3.0, "updatedReplicas": 3.0, "availableReplicas": 3.0}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := map[string]any{
+				"metadata": map[string]any{"name": "web", "generation": 2.0},
+				"spec":     map[string]any{"replicas": 3.0},
+				"status":   test.status,
+			}
+			_, done, err := deploymentStatus(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != test.done {
+				t.Errorf("unexpected done: %v", done)
+			}
+		})
+	}
+}
+
+func TestDeploymentStatusDeadline(t *testing.T) {
+	obj := map[string]any{
+		"metadata": map[string]any{"name": "web", "generation": 1.0},
+		"status": map[string]any{
+			"observedGeneration": 1.0,
+			"conditions": []any{
+				map[string]any{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+			},
+		},
+	}
+	if _, _, err := deploymentStatus(obj); err == nil {
+		t.Errorf("exceeded deadline should fail")
+	}
+}
+
+func TestRolloutStatus(t *testing.T) {
+	fake, client := newFakeServer(t)
+	rolloutInterval = time.Millisecond
+	t.Cleanup(func() { rolloutInterval = 2 * time.Second })
+
+	deployment := testObject("apps/v1", "Deployment", "", "web")
+	deployment["metadata"].(map[string]any)["generation"] = 1.0
+	deployment["status"] = map[string]any{"observedGeneration": 1.0, "replicas": 1.0, "updatedReplicas": 1.0, "availableReplicas": 1.0}
+	fake.objects["/apis/apps/v1/namespaces/default/deployments/web"] = deployment
+
+	if err := client.RolloutStatus(context.Background(), "Deployment", "web", "", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment["status"] = map[string]any{"observedGeneration": 1.0, "replicas": 1.0, "updatedReplicas": 0.0}
+	if err := client.RolloutStatus(context.Background(), "Deployment", "web", "", 50*time.Millisecond); err == nil {
+		t.Errorf("stuck rollout should time out")
+	}
+}
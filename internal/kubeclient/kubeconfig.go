@@ -0,0 +1,190 @@
+package kubeclient
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/altipla-consulting/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Kubeconfig contains the subset of the kubectl configuration file needed to
+// connect to a cluster.
+type Kubeconfig struct {
+	CurrentContext string         `json:"current-context"`
+	Clusters       []NamedCluster `json:"clusters"`
+	Contexts       []NamedContext `json:"contexts"`
+	Users          []NamedUser    `json:"users"`
+}
+
+type NamedCluster struct {
+	Name    string  `json:"name"`
+	Cluster Cluster `json:"cluster"`
+}
+
+type Cluster struct {
+	Server                   string `json:"server"`
+	CertificateAuthority     string `json:"certificate-authority"`
+	CertificateAuthorityData string `json:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+	TLSServerName            string `json:"tls-server-name"`
+}
+
+type NamedContext struct {
+	Name    string  `json:"name"`
+	Context Context `json:"context"`
+}
+
+type Context struct {
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+}
+
+type NamedUser struct {
+	Name string   `json:"name"`
+	User AuthInfo `json:"user"`
+}
+
+type AuthInfo struct {
+	ClientCertificate     string      `json:"client-certificate"`
+	ClientCertificateData string      `json:"client-certificate-data"`
+	ClientKey             string      `json:"client-key"`
+	ClientKeyData         string      `json:"client-key-data"`
+	Token                 string      `json:"token"`
+	TokenFile             string      `json:"tokenFile"`
+	Username              string      `json:"username"`
+	Password              string      `json:"password"`
+	Exec                  *ExecConfig `json:"exec"`
+}
+
+// ExecConfig runs an external credential plugin like gke-gcloud-auth-plugin or kubelogin.
+type ExecConfig struct {
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Env        []EnvVar `json:"env"`
+	APIVersion string   `json:"apiVersion"`
+}
+
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LoadKubeconfig reads and merges the files of the KUBECONFIG environment variable,
+// or ~/.kube/config if it is not defined. The first file that defines a value wins
+// like kubectl does.
+func LoadKubeconfig() (*Kubeconfig, error) {
+	var filenames []string
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		filenames = filepath.SplitList(env)
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		filenames = []string{filepath.Join(home, ".kube", "config")}
+	}
+
+	merged := new(Kubeconfig)
+	clusters := make(map[string]bool)
+	contexts := make(map[string]bool)
+	users := make(map[string]bool)
+	var found bool
+	for _, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Trace(err)
+		}
+		found = true
+
+		config := new(Kubeconfig)
+		if err := yaml.Unmarshal(content, config); err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.resolvePaths(filepath.Dir(filename))
+
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = config.CurrentContext
+		}
+		for _, c := range config.Clusters {
+			if !clusters[c.Name] {
+				clusters[c.Name] = true
+				merged.Clusters = append(merged.Clusters, c)
+			}
+		}
+		for _, c := range config.Contexts {
+			if !contexts[c.Name] {
+				contexts[c.Name] = true
+				merged.Contexts = append(merged.Contexts, c)
+			}
+		}
+		for _, u := range config.Users {
+			if !users[u.Name] {
+				users[u.Name] = true
+				merged.Users = append(merged.Users, u)
+			}
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("cannot find a kubeconfig file in %v", filenames)
+	}
+	return merged, nil
+}
+
+// resolvePaths makes the file references relative to the folder of the kubeconfig file.
+func (config *Kubeconfig) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range config.Clusters {
+		resolve(&config.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range config.Users {
+		resolve(&config.Users[i].User.ClientCertificate)
+		resolve(&config.Users[i].User.ClientKey)
+		resolve(&config.Users[i].User.TokenFile)
+	}
+}
+
+// Resolve returns the context, cluster and user of the context with that name, or
+// of the current context if the name is empty.
+func (config *Kubeconfig) Resolve(name string) (*Context, *Cluster, *AuthInfo, error) {
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		return nil, nil, nil, errors.Errorf("no current context configured in the kubeconfig")
+	}
+
+	var ctx *Context
+	for _, c := range config.Contexts {
+		if c.Name == name {
+			ctx = &c.Context
+		}
+	}
+	if ctx == nil {
+		return nil, nil, nil, errors.Errorf("cannot find context %q in the kubeconfig", name)
+	}
+	var cluster *Cluster
+	for _, c := range config.Clusters {
+		if c.Name == ctx.Cluster {
+			cluster = &c.Cluster
+		}
+	}
+	if cluster == nil {
+		return nil, nil, nil, errors.Errorf("cannot find cluster %q in the kubeconfig", ctx.Cluster)
+	}
+	user := new(AuthInfo)
+	for _, u := range config.Users {
+		if u.Name == ctx.User {
+			user = &u.User
+		}
+	}
+	return ctx, cluster, user, nil
+}
@@ -0,0 +1,132 @@
+package kubeclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// ListOptions filters the objects returned by List.
+type ListOptions struct {
+	LabelSelector string
+	FieldSelector string
+}
+
+// Get returns the live object of the cluster. Namespaced objects without
+// namespace are read from the namespace of the client.
+func (client *Client) Get(ctx context.Context, apiVersion, kind, namespace, name string) (map[string]any, error) {
+	resource, err := client.Resource(ctx, apiVersion, kind)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resource.Namespaced && namespace == "" {
+		namespace = client.Namespace
+	}
+	var obj map[string]any
+	if _, err := client.do(ctx, http.MethodGet, resourcePath(apiVersion, resource, namespace, name), "", nil, &obj); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return obj, nil
+}
+
+// List returns the objects of the kind in the namespace, or in every namespace
+// if empty.
+func (client *Client) List(ctx context.Context, apiVersion, kind, namespace string, opts ListOptions) ([]map[string]any, error) {
+	resource, err := client.Resource(ctx, apiVersion, kind)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return client.list(ctx, apiVersion, resource, namespace, opts)
+}
+
+func (client *Client) list(ctx context.Context, apiVersion string, resource *APIResource, namespace string, opts ListOptions) ([]map[string]any, error) {
+	query := make(url.Values)
+	if opts.LabelSelector != "" {
+		query.Set("labelSelector", opts.LabelSelector)
+	}
+	if opts.FieldSelector != "" {
+		query.Set("fieldSelector", opts.FieldSelector)
+	}
+	path := resourcePath(apiVersion, resource, namespace, "")
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var list struct {
+		Items []map[string]any `json:"items"`
+	}
+	if _, err := client.do(ctx, http.MethodGet, path, "", nil, &list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The items of the lists do not repeat their type.
+	for _, item := range list.Items {
+		item["apiVersion"] = apiVersion
+		item["kind"] = resource.Kind
+	}
+	return list.Items, nil
+}
+
+// ListLabeled returns every object of any kind in the cluster that matches the
+// label selector. Only the kinds that can be listed and deleted are searched.
+func (client *Client) ListLabeled(ctx context.Context, selector string) ([]map[string]any, error) {
+	versions, err := client.APIVersions(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var objects []map[string]any
+	for _, apiVersion := range versions {
+		resources, err := client.Resources(ctx, apiVersion)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, resource := range resources {
+			if !resource.Supports("list", "delete") {
+				continue
+			}
+			items, err := client.list(ctx, apiVersion, &resource, "", ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			objects = append(objects, items...)
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the object from the cluster. Only the apiVersion, kind, name
+// and namespace of the object are used.
+func (client *Client) Delete(ctx context.Context, obj map[string]any) error {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+
+	resource, err := client.Resource(ctx, apiVersion, kind)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resource.Namespaced && namespace == "" {
+		namespace = client.Namespace
+	}
+	if _, err := client.do(ctx, http.MethodDelete, resourcePath(apiVersion, resource, namespace, name), "", nil, nil); err != nil && !IsNotFound(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Logs returns the last lines of the logs of a container of the pod.
+func (client *Client) Logs(ctx context.Context, namespace, pod, container string, lines int) (string, error) {
+	query := url.Values{
+		"container": {container},
+		"tailLines": {strconv.Itoa(lines)},
+	}
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(pod) + "/log?" + query.Encode()
+	_, reply, err := client.send(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(reply), nil
+}
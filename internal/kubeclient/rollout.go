@@ -0,0 +1,135 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// rolloutInterval is the time between checks of the status of a rollout.
+var rolloutInterval = 2 * time.Second
+
+// RolloutStatus waits until the rollout of the Deployment or StatefulSet finishes
+// or fails, with the same rules as kubectl rollout status.
+func (client *Client) RolloutStatus(ctx context.Context, kind, name, namespace string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last string
+	for {
+		obj, err := client.Get(ctx, "apps/v1", kind, namespace, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.Errorf("timeout waiting for the rollout of %s %s: %s", kind, name, last)
+			}
+			return errors.Trace(err)
+		}
+		var msg string
+		var done bool
+		switch kind {
+		case "Deployment":
+			msg, done, err = deploymentStatus(obj)
+		case "StatefulSet":
+			msg, done, err = statefulSetStatus(obj)
+		default:
+			return errors.Errorf("cannot track the rollout of kind %s", kind)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if msg != last {
+			slog.Info(msg, slog.String("kind", kind), slog.String("name", name))
+			last = msg
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("timeout waiting for the rollout of %s %s: %s", kind, name, last)
+		case <-time.After(rolloutInterval):
+		}
+	}
+}
+
+func deploymentStatus(obj map[string]any) (string, bool, error) {
+	metadata, _ := obj["metadata"].(map[string]any)
+	spec, _ := obj["spec"].(map[string]any)
+	status, _ := obj["status"].(map[string]any)
+	if number(metadata["generation"]) > number(status["observedGeneration"]) {
+		return "Waiting for the deployment spec update to be observed", false, nil
+	}
+	conditions, _ := status["conditions"].([]any)
+	for _, c := range conditions {
+		if c, ok := c.(map[string]any); ok && c["type"] == "Progressing" && c["reason"] == "ProgressDeadlineExceeded" {
+			return "", false, errors.Errorf("deployment %s exceeded its progress deadline", metadata["name"])
+		}
+	}
+
+	replicas := int64(1)
+	if r, ok := spec["replicas"]; ok {
+		replicas = number(r)
+	}
+	updated := number(status["updatedReplicas"])
+	available := number(status["availableReplicas"])
+	switch {
+	case updated < replicas:
+		return fmt.Sprintf("Waiting for rollout to finish: %d out of %d new replicas have been updated", updated, replicas), false, nil
+	case number(status["replicas"]) > updated:
+		return fmt.Sprintf("Waiting for rollout to finish: %d old replicas are pending termination", number(status["replicas"])-updated), false, nil
+	case available < updated:
+		return fmt.Sprintf("Waiting for rollout to finish: %d of %d updated replicas are available", available, updated), false, nil
+	}
+	return "Rollout finished", true, nil
+}
+
+func statefulSetStatus(obj map[string]any) (string, bool, error) {
+	metadata, _ := obj["metadata"].(map[string]any)
+	spec, _ := obj["spec"].(map[string]any)
+	status, _ := obj["status"].(map[string]any)
+	strategy, _ := spec["updateStrategy"].(map[string]any)
+	if t, ok := strategy["type"]; ok && t != "RollingUpdate" {
+		return "", false, errors.Errorf("rollout status is only available for the RollingUpdate strategy of statefulset %s", metadata["name"])
+	}
+	if number(metadata["generation"]) > number(status["observedGeneration"]) {
+		return "Waiting for the statefulset spec update to be observed", false, nil
+	}
+
+	replicas := int64(1)
+	if r, ok := spec["replicas"]; ok {
+		replicas = number(r)
+	}
+	ready := number(status["readyReplicas"])
+	if ready < replicas {
+		return fmt.Sprintf("Waiting for %d pods to be ready", replicas-ready), false, nil
+	}
+	rollingUpdate, _ := strategy["rollingUpdate"].(map[string]any)
+	if partition, ok := rollingUpdate["partition"]; ok && number(partition) > 0 {
+		updated := number(status["updatedReplicas"])
+		if updated < replicas-number(partition) {
+			return fmt.Sprintf("Waiting for partitioned rollout to finish: %d out of %d new pods have been updated", updated, replicas-number(partition)), false, nil
+		}
+		return "Partitioned rollout finished", true, nil
+	}
+	if status["updateRevision"] != status["currentRevision"] {
+		return fmt.Sprintf("Waiting for rollout to finish: %d out of %d new pods have been updated", number(status["updatedReplicas"]), replicas), false, nil
+	}
+	return "Rollout finished", true, nil
+}
+
+// number reads the integers decoded from JSON.
+func number(v any) int64 {
+	switch v := v.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
+}
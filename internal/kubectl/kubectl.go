@@ -0,0 +1,246 @@
+package kubectl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// FieldManager is the name used to own the fields applied with server-side apply.
+const FieldManager = "wave"
+
+type ApplyOptions struct {
+	// ServerSide enables server-side apply with FieldManager as the owner.
+	ServerSide bool
+}
+
+// Apply sends the list of objects read from r to the cluster.
+func Apply(ctx context.Context, r io.Reader, opts ApplyOptions) error {
+	args := []string{"apply", "-f", "-"}
+	if opts.ServerSide {
+		args = append(args, "--server-side", "--field-manager", FieldManager)
+	}
+	return errors.Trace(run(ctx, r, os.Stdout, args...))
+}
+
+// Diff compares the list of objects read from r with the live state of the cluster
+// and returns the unified diff. It returns an empty string if nothing changes.
+func Diff(ctx context.Context, r io.Reader, opts ApplyOptions) (string, error) {
+	args := []string{"diff", "-f", "-"}
+	if opts.ServerSide {
+		args = append(args, "--server-side", "--field-manager", FieldManager)
+	}
+	var buf bytes.Buffer
+	if err := run(ctx, r, &buf, args...); err != nil {
+		// Exit code 1 means there are differences.
+		if exit := new(exec.ExitError); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+			return "", errors.Trace(err)
+		}
+	}
+	return buf.String(), nil
+}
+
+// CurrentNamespace returns the namespace configured in the current context.
+func CurrentNamespace(ctx context.Context) (string, error) {
+	var buf bytes.Buffer
+	if err := run(ctx, nil, &buf, "config", "view", "--minify", "-o", "jsonpath={..namespace}"); err != nil {
+		return "", errors.Trace(err)
+	}
+	if ns := strings.TrimSpace(buf.String()); ns != "" {
+		return ns, nil
+	}
+	return "default", nil
+}
+
+// ListLabeled returns every object of any kind in the cluster that matches the label selector.
+func ListLabeled(ctx context.Context, selector string) ([]map[string]any, error) {
+	var resources bytes.Buffer
+	if err := run(ctx, nil, &resources, "api-resources", "--verbs=list,delete", "-o", "name"); err != nil {
+		return nil, errors.Trace(err)
+	}
+	names := strings.Fields(resources.String())
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := run(ctx, nil, &buf, "get", strings.Join(names, ","), "--all-namespaces", "--ignore-not-found", "-l", selector, "-o", "json"); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	var list struct {
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+// Delete removes the objects from the cluster. Only the apiVersion, kind, name
+// and namespace of each object are used.
+func Delete(ctx context.Context, objects []map[string]any) error {
+	list := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "List",
+	}
+	var items []any
+	for _, obj := range objects {
+		items = append(items, map[string]any{
+			"apiVersion": obj["apiVersion"],
+			"kind":       obj["kind"],
+			"metadata": map[string]any{
+				"name":      Name(obj),
+				"namespace": Namespace(obj),
+			},
+		})
+	}
+	list["items"] = items
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(list); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(run(ctx, &buf, os.Stdout, "delete", "-f", "-"))
+}
+
+// RolloutStatus waits until the rollout of the workload finishes or fails.
+func RolloutStatus(ctx context.Context, kind, name, namespace string, timeout time.Duration) error {
+	args := []string{"rollout", "status", kind + "/" + name, "--timeout", timeout.String()}
+	return errors.Trace(run(ctx, nil, os.Stdout, withNamespace(args, namespace)...))
+}
+
+// RolloutUndo rolls back the workload to its previous revision.
+func RolloutUndo(ctx context.Context, kind, name, namespace string) error {
+	args := []string{"rollout", "undo", kind + "/" + name}
+	return errors.Trace(run(ctx, nil, os.Stdout, withNamespace(args, namespace)...))
+}
+
+// Pods returns the pods that match the label selector.
+func Pods(ctx context.Context, namespace, selector string) ([]map[string]any, error) {
+	args := []string{"get", "pods", "-l", selector, "-o", "json"}
+	var buf bytes.Buffer
+	if err := run(ctx, nil, &buf, withNamespace(args, namespace)...); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var list struct {
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return list.Items, nil
+}
+
+// PrintEvents prints the events of the pod to stdout.
+func PrintEvents(ctx context.Context, namespace, pod string) error {
+	args := []string{"get", "events", "--field-selector", "involvedObject.kind=Pod,involvedObject.name=" + pod, "--sort-by", ".lastTimestamp"}
+	return errors.Trace(run(ctx, nil, os.Stdout, withNamespace(args, namespace)...))
+}
+
+// PrintLogs prints the last lines of the logs of every container of the pod to stdout.
+func PrintLogs(ctx context.Context, namespace, pod string, lines int) error {
+	args := []string{"logs", pod, "--all-containers", "--prefix", "--tail", strconv.Itoa(lines)}
+	return errors.Trace(run(ctx, nil, os.Stdout, withNamespace(args, namespace)...))
+}
+
+func withNamespace(args []string, namespace string) []string {
+	if namespace != "" {
+		return append(args, "--namespace", namespace)
+	}
+	return args
+}
+
+// Name returns the name of the object.
+func Name(obj map[string]any) string {
+	metadata, _ := obj["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+// Namespace returns the namespace of the object, or empty if it has none.
+func Namespace(obj map[string]any) string {
+	metadata, _ := obj["metadata"].(map[string]any)
+	ns, _ := metadata["namespace"].(string)
+	return ns
+}
+
+// clusterScoped contains the built-in kinds that do not live inside a namespace.
+var clusterScoped = map[string]bool{
+	"/Namespace":        true,
+	"/Node":             true,
+	"/PersistentVolume": true,
+	"admissionregistration.k8s.io/MutatingWebhookConfiguration":     true,
+	"admissionregistration.k8s.io/ValidatingAdmissionPolicy":        true,
+	"admissionregistration.k8s.io/ValidatingAdmissionPolicyBinding": true,
+	"admissionregistration.k8s.io/ValidatingWebhookConfiguration":   true,
+	"apiextensions.k8s.io/CustomResourceDefinition":                 true,
+	"apiregistration.k8s.io/APIService":                             true,
+	"certificates.k8s.io/CertificateSigningRequest":                 true,
+	"flowcontrol.apiserver.k8s.io/FlowSchema":                       true,
+	"flowcontrol.apiserver.k8s.io/PriorityLevelConfiguration":       true,
+	"gateway.networking.k8s.io/GatewayClass":                        true,
+	"networking.k8s.io/IngressClass":                                true,
+	"node.k8s.io/RuntimeClass":                                      true,
+	"rbac.authorization.k8s.io/ClusterRole":                         true,
+	"rbac.authorization.k8s.io/ClusterRoleBinding":                  true,
+	"scheduling.k8s.io/PriorityClass":                               true,
+	"storage.k8s.io/CSIDriver":                                      true,
+	"storage.k8s.io/CSINode":                                        true,
+	"storage.k8s.io/StorageClass":                                   true,
+	"storage.k8s.io/VolumeAttachment":                               true,
+}
+
+// ClusterScoped returns true if the object is a built-in kind that does not live
+// inside a namespace. Custom resources are always reported as namespaced.
+func ClusterScoped(obj map[string]any) bool {
+	kind, _ := obj["kind"].(string)
+	return clusterScoped[Group(obj)+"/"+kind]
+}
+
+// Group returns the API group of the object, empty for the core group.
+func Group(obj map[string]any) string {
+	apiVersion, _ := obj["apiVersion"].(string)
+	if group, _, ok := strings.Cut(apiVersion, "/"); ok {
+		return group
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// WithContext runs every command of ctx against the kubectl context with that
+// name instead of the current one.
+func WithContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// ContextName returns the kubectl context selected with WithContext, or empty
+// to use the current one.
+func ContextName(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+func run(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	if name := ContextName(ctx); name != "" {
+		args = append([]string{"--context", name}, args...)
+	}
+	slog.Debug(strings.Join(append([]string{"kubectl"}, args...), " "))
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	return errors.Trace(cmd.Run())
+}
@@ -0,0 +1,125 @@
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"os"
+
+	"github.com/altipla-consulting/errors"
+)
+
+// Scope limits where the controller accepts to unseal the secret.
+type Scope string
+
+const (
+	// ScopeStrict binds the secret to its name and namespace.
+	ScopeStrict Scope = "strict"
+
+	// ScopeNamespaceWide allows to rename the secret inside its namespace.
+	ScopeNamespaceWide Scope = "namespace-wide"
+
+	// ScopeClusterWide allows to rename the secret and move it to any namespace.
+	ScopeClusterWide Scope = "cluster-wide"
+)
+
+func (scope Scope) label(namespace, name string) []byte {
+	switch scope {
+	case ScopeNamespaceWide:
+		return []byte(namespace)
+	case ScopeClusterWide:
+		return nil
+	}
+	return []byte(namespace + "/" + name)
+}
+
+// ReadCertificate reads the public certificate of the controller, the output of
+// kubeseal --fetch-cert.
+func ReadCertificate(filename string) (*rsa.PublicKey, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data found in %s", filename)
+	}
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Errorf("cannot read %s: %s", filename, err)
+		}
+		key = cert.PublicKey
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Errorf("cannot read %s: %s", filename, err)
+		}
+	default:
+		return nil, errors.Errorf("unexpected PEM block %q in %s", block.Type, filename)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("the certificate %s does not contain a RSA key", filename)
+	}
+	return rsaKey, nil
+}
+
+// Seal encrypts the values of the secret like kubeseal does and returns them
+// encoded in base64, ready for the encryptedData field.
+func Seal(key *rsa.PublicKey, scope Scope, namespace, name string, data map[string]string) (map[string]string, error) {
+	switch scope {
+	case ScopeStrict, ScopeNamespaceWide:
+		if namespace == "" {
+			return nil, errors.Errorf("missing namespace to seal the secret %s with %s scope", name, scope)
+		}
+	case ScopeClusterWide:
+	default:
+		return nil, errors.Errorf("unknown scope %q", scope)
+	}
+
+	encrypted := make(map[string]string)
+	for k, v := range data {
+		ciphertext, err := hybridEncrypt(key, []byte(v), scope.label(namespace, name))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		encrypted[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+	return encrypted, nil
+}
+
+// hybridEncrypt encrypts the value with a random session key that is encrypted
+// itself with the public key of the controller.
+func hybridEncrypt(key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, errors.Trace(err)
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, label)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ciphertext := binary.BigEndian.AppendUint16(nil, uint16(len(encryptedKey)))
+	ciphertext = append(ciphertext, encryptedKey...)
+	// The session key is used only once, a zero nonce is safe.
+	nonce := make([]byte, gcm.NonceSize())
+	return gcm.Seal(ciphertext, nonce, plaintext, nil), nil
+}
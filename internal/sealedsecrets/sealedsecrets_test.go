@@ -0,0 +1,134 @@
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unseal decrypts the value like the controller does.
+func unseal(t *testing.T, key *rsa.PrivateKey, value string, label []byte) (string, error) {
+	t.Helper()
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+size], label)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, make([]byte, gcm.NonceSize()), ciphertext[2+size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	key := generateKey(t)
+	tests := []struct {
+		scope Scope
+		label string
+	}{
+		{ScopeStrict, "prod/db"},
+		{ScopeNamespaceWide, "prod"},
+		{ScopeClusterWide, ""},
+	}
+	for _, test := range tests {
+		t.Run(string(test.scope), func(t *testing.T) {
+			sealed, err := Seal(&key.PublicKey, test.scope, "prod", "db", map[string]string{
+				"password": "s3cret",
+				"empty":    "",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range map[string]string{"password": "s3cret", "empty": ""} {
+				got, err := unseal(t, key, sealed[k], []byte(test.label))
+				if err != nil {
+					t.Fatalf("cannot unseal %s: %s", k, err)
+				}
+				if got != want {
+					t.Errorf("unexpected value of %s: %q", k, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSealStrictRejectsRename(t *testing.T) {
+	key := generateKey(t)
+	sealed, err := Seal(&key.PublicKey, ScopeStrict, "prod", "db", map[string]string{"password": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unseal(t, key, sealed["password"], []byte("prod/other")); err == nil {
+		t.Errorf("the value should not unseal with another name")
+	}
+}
+
+func TestSealMissingNamespace(t *testing.T) {
+	key := generateKey(t)
+	if _, err := Seal(&key.PublicKey, ScopeStrict, "", "db", map[string]string{"password": "s3cret"}); err == nil {
+		t.Errorf("strict scope without namespace should fail")
+	}
+	if _, err := Seal(&key.PublicKey, Scope("unknown"), "prod", "db", nil); err == nil {
+		t.Errorf("unknown scope should fail")
+	}
+}
+
+func TestReadCertificate(t *testing.T) {
+	key := generateKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ReadCertificate(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(&key.PublicKey) {
+		t.Errorf("unexpected public key")
+	}
+}
@@ -0,0 +1,53 @@
+package validate
+
+type deprecation struct {
+	apiVersion string
+
+	// kinds affected by the deprecation. Empty means every kind of the apiVersion.
+	kinds []string
+
+	// removed is the Kubernetes version that stopped serving the apiVersion. Empty
+	// if it is deprecated but still served.
+	removed string
+
+	replacement string
+}
+
+// deprecations follows the official migration guide:
+// https://kubernetes.io/docs/reference/using-api/deprecation-guide/
+var deprecations = []deprecation{
+	{apiVersion: "extensions/v1beta1", kinds: []string{"Ingress"}, removed: "1.22", replacement: "networking.k8s.io/v1"},
+	{apiVersion: "extensions/v1beta1", kinds: []string{"NetworkPolicy"}, removed: "1.16", replacement: "networking.k8s.io/v1"},
+	{apiVersion: "extensions/v1beta1", kinds: []string{"PodSecurityPolicy"}, removed: "1.16", replacement: "Pod Security Admission"},
+	{apiVersion: "extensions/v1beta1", removed: "1.16", replacement: "apps/v1"},
+	{apiVersion: "apps/v1beta1", removed: "1.16", replacement: "apps/v1"},
+	{apiVersion: "apps/v1beta2", removed: "1.16", replacement: "apps/v1"},
+
+	{apiVersion: "admissionregistration.k8s.io/v1beta1", removed: "1.22", replacement: "admissionregistration.k8s.io/v1"},
+	{apiVersion: "apiextensions.k8s.io/v1beta1", removed: "1.22", replacement: "apiextensions.k8s.io/v1"},
+	{apiVersion: "apiregistration.k8s.io/v1beta1", removed: "1.22", replacement: "apiregistration.k8s.io/v1"},
+	{apiVersion: "certificates.k8s.io/v1beta1", removed: "1.22", replacement: "certificates.k8s.io/v1"},
+	{apiVersion: "coordination.k8s.io/v1beta1", removed: "1.22", replacement: "coordination.k8s.io/v1"},
+	{apiVersion: "networking.k8s.io/v1beta1", kinds: []string{"Ingress", "IngressClass"}, removed: "1.22", replacement: "networking.k8s.io/v1"},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", removed: "1.22", replacement: "rbac.authorization.k8s.io/v1"},
+	{apiVersion: "scheduling.k8s.io/v1beta1", removed: "1.22", replacement: "scheduling.k8s.io/v1"},
+	{apiVersion: "storage.k8s.io/v1beta1", kinds: []string{"CSIDriver", "CSINode", "StorageClass", "VolumeAttachment"}, removed: "1.22", replacement: "storage.k8s.io/v1"},
+
+	{apiVersion: "batch/v1beta1", kinds: []string{"CronJob"}, removed: "1.25", replacement: "batch/v1"},
+	{apiVersion: "discovery.k8s.io/v1beta1", kinds: []string{"EndpointSlice"}, removed: "1.25", replacement: "discovery.k8s.io/v1"},
+	{apiVersion: "events.k8s.io/v1beta1", kinds: []string{"Event"}, removed: "1.25", replacement: "events.k8s.io/v1"},
+	{apiVersion: "autoscaling/v2beta1", kinds: []string{"HorizontalPodAutoscaler"}, removed: "1.25", replacement: "autoscaling/v2"},
+	{apiVersion: "policy/v1beta1", kinds: []string{"PodDisruptionBudget"}, removed: "1.25", replacement: "policy/v1"},
+	{apiVersion: "policy/v1beta1", kinds: []string{"PodSecurityPolicy"}, removed: "1.25", replacement: "Pod Security Admission"},
+	{apiVersion: "node.k8s.io/v1beta1", kinds: []string{"RuntimeClass"}, removed: "1.25", replacement: "node.k8s.io/v1"},
+
+	{apiVersion: "flowcontrol.apiserver.k8s.io/v1beta1", removed: "1.26", replacement: "flowcontrol.apiserver.k8s.io/v1"},
+	{apiVersion: "autoscaling/v2beta2", kinds: []string{"HorizontalPodAutoscaler"}, removed: "1.26", replacement: "autoscaling/v2"},
+	{apiVersion: "storage.k8s.io/v1beta1", kinds: []string{"CSIStorageCapacity"}, removed: "1.27", replacement: "storage.k8s.io/v1"},
+	{apiVersion: "flowcontrol.apiserver.k8s.io/v1beta2", removed: "1.29", replacement: "flowcontrol.apiserver.k8s.io/v1"},
+	{apiVersion: "flowcontrol.apiserver.k8s.io/v1beta3", removed: "1.32", replacement: "flowcontrol.apiserver.k8s.io/v1"},
+
+	// AAD Pod Identity was archived in favor of Microsoft Entra Workload ID. It is
+	// generated by identities.Azure of wave.jsonnet.
+	{apiVersion: "aadpodidentity.k8s.io/v1", replacement: "Microsoft Entra Workload ID"},
+}
@@ -0,0 +1,362 @@
+// Command genschemas builds the compact OpenAPI definitions bundled with wave to
+// validate the generated Kubernetes manifests offline.
+//
+// It reads the Go types of the k8s.io/api and k8s.io/apimachinery modules
+// directly from the source code, so it does not need to link any of them:
+//
+//	go mod download -json k8s.io/api@v0.34.1 k8s.io/apimachinery@v0.34.1
+//	go run ./internal/validate/genschemas \
+//		-api $(go env GOMODCACHE)/k8s.io/api@v0.34.1 \
+//		-apimachinery $(go env GOMODCACHE)/k8s.io/apimachinery@v0.34.1 \
+//		-out embed/schemas/kubernetes-1.34.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// groups are the API groups included in the bundle. Custom resources are not
+// validated.
+var groups = []string{
+	"admissionregistration/v1",
+	"apps/v1",
+	"autoscaling/v1",
+	"autoscaling/v2",
+	"batch/v1",
+	"certificates/v1",
+	"coordination/v1",
+	"core/v1",
+	"discovery/v1",
+	"networking/v1",
+	"node/v1",
+	"policy/v1",
+	"rbac/v1",
+	"scheduling/v1",
+	"storage/v1",
+}
+
+const (
+	apiModule          = "k8s.io/api"
+	apimachineryModule = "k8s.io/apimachinery"
+	metav1             = apimachineryModule + "/pkg/apis/meta/v1"
+)
+
+// specials have a custom JSON encoding that does not follow the Go structs.
+var specials = map[string]map[string]any{
+	apimachineryModule + "/pkg/api/resource.Quantity":   {"type": "string", "format": "quantity"},
+	apimachineryModule + "/pkg/util/intstr.IntOrString": {"type": "string", "format": "int-or-string"},
+	apimachineryModule + "/pkg/runtime.RawExtension":    {},
+	apimachineryModule + "/pkg/types.UID":               {"type": "string"},
+	apimachineryModule + "/pkg/types.NodeName":          {"type": "string"},
+	metav1 + ".Time":      {"type": "string", "format": "date-time"},
+	metav1 + ".MicroTime": {"type": "string", "format": "date-time"},
+	metav1 + ".Duration":  {"type": "string"},
+	metav1 + ".FieldsV1":  {},
+}
+
+type typeDecl struct {
+	pkg     string
+	spec    *ast.TypeSpec
+	imports map[string]string
+}
+
+type generator struct {
+	types       map[string]*typeDecl
+	definitions map[string]map[string]any
+}
+
+func main() {
+	var flagAPI, flagAPIMachinery, flagOut string
+	flag.StringVar(&flagAPI, "api", "", "Directory of the k8s.io/api module.")
+	flag.StringVar(&flagAPIMachinery, "apimachinery", "", "Directory of the k8s.io/apimachinery module.")
+	flag.StringVar(&flagOut, "out", "", "Output file.")
+	flag.Parse()
+	if flagAPI == "" || flagAPIMachinery == "" || flagOut == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	g := &generator{
+		types:       make(map[string]*typeDecl),
+		definitions: make(map[string]map[string]any),
+	}
+	if err := g.parse(filepath.Join(flagAPIMachinery, "pkg/apis/meta/v1"), metav1); err != nil {
+		log.Fatal(err)
+	}
+	type kind struct {
+		group, version, name, pkg string
+	}
+	var kinds []kind
+	for _, gv := range groups {
+		pkg := apiModule + "/" + gv
+		dir := filepath.Join(flagAPI, filepath.FromSlash(gv))
+		if err := g.parse(dir, pkg); err != nil {
+			log.Fatal(err)
+		}
+		group, names, err := registeredKinds(filepath.Join(dir, "register.go"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range names {
+			kinds = append(kinds, kind{group, path.Base(gv), name, pkg})
+		}
+	}
+
+	for _, k := range kinds {
+		// Lists are not generated by the scripts.
+		if strings.HasSuffix(k.name, "List") {
+			continue
+		}
+		ref := g.ref(k.pkg, k.name)
+		g.definitions[ref]["x-kubernetes-group-version-kind"] = []map[string]string{
+			{"group": k.group, "version": k.version, "kind": k.name},
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(flagOut), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(flagOut, g.encode(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (g *generator) parse(dir, pkg string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && !strings.HasPrefix(name, "zz_generated") && name != "generated.pb.go"
+	}, 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			imports := make(map[string]string)
+			for _, imp := range f.Imports {
+				importPath, _ := strconv.Unquote(imp.Path.Value)
+				name := path.Base(importPath)
+				if imp.Name != nil {
+					name = imp.Name.Name
+				}
+				imports[name] = importPath
+			}
+			for _, decl := range f.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					g.types[pkg+"."+ts.Name.Name] = &typeDecl{pkg: pkg, spec: ts, imports: imports}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// registeredKinds reads the group name and the types added to the scheme.
+func registeredKinds(filename string) (string, []string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	var group string
+	var kinds []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			if len(n.Names) == 1 && n.Names[0].Name == "GroupName" && len(n.Values) == 1 {
+				if lit, ok := n.Values[0].(*ast.BasicLit); ok {
+					group, _ = strconv.Unquote(lit.Value)
+				}
+			}
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "AddKnownTypes" {
+				for _, arg := range n.Args[1:] {
+					if u, ok := arg.(*ast.UnaryExpr); ok {
+						if lit, ok := u.X.(*ast.CompositeLit); ok {
+							if id, ok := lit.Type.(*ast.Ident); ok {
+								kinds = append(kinds, id.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+	return group, kinds, nil
+}
+
+// ref returns the name of the definition of the struct, generating it the first
+// time it is referenced.
+func (g *generator) ref(pkg, name string) string {
+	ref := definitionName(pkg, name)
+	if _, ok := g.definitions[ref]; ok {
+		return ref
+	}
+	decl, ok := g.types[pkg+"."+name]
+	if !ok {
+		log.Fatalf("unknown type %s.%s", pkg, name)
+	}
+	def := map[string]any{"type": "object"}
+	g.definitions[ref] = def
+	properties := make(map[string]any)
+	g.fields(decl, decl.spec.Type.(*ast.StructType), properties)
+	def["properties"] = properties
+	return ref
+}
+
+func (g *generator) fields(decl *typeDecl, st *ast.StructType, properties map[string]any) {
+	for _, field := range st.Fields.List {
+		var tag string
+		if field.Tag != nil {
+			raw, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(raw).Get("json")
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if len(field.Names) == 0 && name == "" {
+			embedded, ok := g.resolve(decl, field.Type)
+			if !ok {
+				log.Fatalf("cannot inline %s in %s", types.ExprString(field.Type), decl.spec.Name.Name)
+			}
+			g.fields(embedded, embedded.spec.Type.(*ast.StructType), properties)
+			continue
+		}
+		if len(field.Names) > 0 && !field.Names[0].IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Names[0].Name
+		}
+		properties[name] = g.schema(decl, field.Type)
+	}
+}
+
+// resolve finds the declaration of a named type.
+func (g *generator) resolve(decl *typeDecl, expr ast.Expr) (*typeDecl, bool) {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return g.resolve(decl, expr.X)
+	case *ast.Ident:
+		d, ok := g.types[decl.pkg+"."+expr.Name]
+		return d, ok
+	case *ast.SelectorExpr:
+		d, ok := g.types[decl.imports[expr.X.(*ast.Ident).Name]+"."+expr.Sel.Name]
+		return d, ok
+	}
+	return nil, false
+}
+
+func (g *generator) schema(decl *typeDecl, expr ast.Expr) map[string]any {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return g.schema(decl, expr.X)
+
+	case *ast.ArrayType:
+		if id, ok := expr.Elt.(*ast.Ident); ok && id.Name == "byte" {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(decl, expr.Elt)}
+
+	case *ast.MapType:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(decl, expr.Value)}
+
+	case *ast.InterfaceType:
+		return map[string]any{}
+
+	case *ast.Ident:
+		switch expr.Name {
+		case "string":
+			return map[string]any{"type": "string"}
+		case "bool":
+			return map[string]any{"type": "boolean"}
+		case "int", "int32", "int64", "uint", "uint32", "uint64":
+			return map[string]any{"type": "integer"}
+		case "float32", "float64":
+			return map[string]any{"type": "number"}
+		}
+		return g.named(decl.pkg, expr.Name)
+
+	case *ast.SelectorExpr:
+		pkg, ok := decl.imports[expr.X.(*ast.Ident).Name]
+		if !ok {
+			log.Fatalf("unknown import %s", types.ExprString(expr))
+		}
+		return g.named(pkg, expr.Sel.Name)
+	}
+	log.Fatalf("unsupported type %s in %s", types.ExprString(expr), decl.spec.Name.Name)
+	return nil
+}
+
+func (g *generator) named(pkg, name string) map[string]any {
+	if special, ok := specials[pkg+"."+name]; ok {
+		return special
+	}
+	decl, ok := g.types[pkg+"."+name]
+	if !ok {
+		log.Fatalf("unknown type %s.%s", pkg, name)
+	}
+	if _, ok := decl.spec.Type.(*ast.StructType); ok {
+		return map[string]any{"$ref": "#/definitions/" + g.ref(pkg, name)}
+	}
+	return g.schema(decl, decl.spec.Type)
+}
+
+// encode writes a definition per line to keep the diffs readable when the file
+// is regenerated.
+func (g *generator) encode() []byte {
+	var names []string
+	for name := range g.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString("{\"definitions\":{\n")
+	for i, name := range names {
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(g.definitions[name])
+		if err != nil {
+			log.Fatal(err)
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+		if i < len(names)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}}\n")
+	return buf.Bytes()
+}
+
+// definitionName follows the naming of the official OpenAPI documents, for
+// example io.k8s.api.apps.v1.Deployment.
+func definitionName(pkg, name string) string {
+	host, rest, _ := strings.Cut(pkg, "/")
+	parts := strings.Split(host, ".")
+	slices.Reverse(parts)
+	parts = append(parts, strings.Split(rest, "/")...)
+	return strings.Join(append(parts, name), ".")
+}
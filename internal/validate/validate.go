@@ -0,0 +1,361 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/altipla-consulting/errors"
+
+	"github.com/altipla-consulting/wave/embed"
+)
+
+// Problem is a single issue found in an object.
+type Problem struct {
+	// Field is the path of the offending field inside the object. It is empty
+	// when the problem affects the whole object.
+	Field string
+
+	Message string
+
+	// Warning problems do not prevent the deployment.
+	Warning bool
+}
+
+type schema struct {
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Ref                  string             `json:"$ref"`
+	Properties           map[string]*schema `json:"properties"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	GroupVersionKind     []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind"`
+}
+
+// Validator checks objects against the schemas of a Kubernetes version without
+// contacting any cluster.
+type Validator struct {
+	version     string
+	definitions map[string]*schema
+
+	// kinds maps apiVersion/kind to the name of the definition.
+	kinds map[string]string
+
+	// groups contains the API groups with bundled schemas.
+	groups map[string]bool
+}
+
+var reSchemaFile = regexp.MustCompile(`^kubernetes-(\d+\.\d+)\.json$`)
+
+// Versions returns the Kubernetes versions with bundled schemas from oldest to newest.
+func Versions() []string {
+	entries, err := fs.ReadDir(embed.Schemas, "schemas")
+	if err != nil {
+		panic(err)
+	}
+	var versions []string
+	for _, entry := range entries {
+		if m := reSchemaFile.FindStringSubmatch(entry.Name()); m != nil {
+			versions = append(versions, m[1])
+		}
+	}
+	slices.SortFunc(versions, compareVersions)
+	return versions
+}
+
+// CheckVersion returns an error if there are no bundled schemas for the
+// Kubernetes version.
+func CheckVersion(version string) error {
+	if _, err := parseVersion(version); err != nil {
+		return errors.Trace(err)
+	}
+	if versions := Versions(); !slices.Contains(versions, version) {
+		return errors.Errorf("no bundled schemas for Kubernetes %s, supported versions: %s", version, strings.Join(versions, ", "))
+	}
+	return nil
+}
+
+// New builds a validator for the Kubernetes version, for example "1.34". The
+// version should have bundled schemas.
+func New(version string) (*Validator, error) {
+	definitions, err := loadDefinitions(version)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	v := &Validator{
+		version:     version,
+		definitions: definitions,
+		kinds:       make(map[string]string),
+		groups:      make(map[string]bool),
+	}
+	for name, def := range definitions {
+		for _, gvk := range def.GroupVersionKind {
+			apiVersion := gvk.Version
+			if gvk.Group != "" {
+				apiVersion = gvk.Group + "/" + gvk.Version
+			}
+			v.kinds[apiVersion+"/"+gvk.Kind] = name
+			v.groups[gvk.Group] = true
+		}
+	}
+	return v, nil
+}
+
+// APIVersions returns the API versions, and the same versions followed by each
+// of their kinds, available in the Kubernetes version according to the bundled
+// schemas. For example apps/v1 and apps/v1/Deployment.
+func APIVersions(version string) ([]string, error) {
+	definitions, err := loadDefinitions(version)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	found := make(map[string]bool)
+	for _, def := range definitions {
+		for _, gvk := range def.GroupVersionKind {
+			apiVersion := gvk.Version
+			if gvk.Group != "" {
+				apiVersion = gvk.Group + "/" + gvk.Version
+			}
+			found[apiVersion] = true
+			found[apiVersion+"/"+gvk.Kind] = true
+		}
+	}
+	versions := make([]string, 0, len(found))
+	for v := range found {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+func loadDefinitions(version string) (map[string]*schema, error) {
+	if err := CheckVersion(version); err != nil {
+		return nil, errors.Trace(err)
+	}
+	content, err := fs.ReadFile(embed.Schemas, path.Join("schemas", "kubernetes-"+version+".json"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var doc struct {
+		Definitions map[string]*schema `json:"definitions"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return doc.Definitions, nil
+}
+
+// Validate checks the object and returns the problems found sorted by field.
+// Objects of custom resources without a known deprecation are not checked.
+func (v *Validator) Validate(obj map[string]any) []Problem {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	if apiVersion == "" || kind == "" {
+		return []Problem{{Message: "missing apiVersion or kind"}}
+	}
+
+	if problem, ok := v.deprecation(apiVersion, kind); ok {
+		return []Problem{problem}
+	}
+
+	name, ok := v.kinds[apiVersion+"/"+kind]
+	if !ok {
+		group, _, found := strings.Cut(apiVersion, "/")
+		if !found {
+			group = ""
+		}
+		if v.groups[group] {
+			return []Problem{{Message: fmt.Sprintf("unknown kind %s in %s for Kubernetes %s", kind, apiVersion, v.version)}}
+		}
+		return nil
+	}
+
+	var problems []Problem
+	v.walk(&problems, "", v.definitions[name], obj)
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Field < problems[j].Field
+	})
+	return problems
+}
+
+func (v *Validator) deprecation(apiVersion, kind string) (Problem, bool) {
+	for _, d := range deprecations {
+		if d.apiVersion != apiVersion || (len(d.kinds) > 0 && !slices.Contains(d.kinds, kind)) {
+			continue
+		}
+		msg := fmt.Sprintf("apiVersion %s of %s", apiVersion, kind)
+		if d.removed != "" && compareVersions(v.version, d.removed) >= 0 {
+			msg += " was removed in Kubernetes " + d.removed
+		} else if d.removed != "" {
+			msg += " is deprecated and will be removed in Kubernetes " + d.removed
+		} else {
+			msg += " is deprecated"
+		}
+		if d.replacement != "" {
+			msg += ", use " + d.replacement + " instead"
+		}
+		return Problem{
+			Message: msg,
+			Warning: d.removed == "" || compareVersions(v.version, d.removed) < 0,
+		}, true
+	}
+	return Problem{}, false
+}
+
+func (v *Validator) walk(problems *[]Problem, field string, s *schema, value any) {
+	if value == nil || s == nil {
+		return
+	}
+	if s.Ref != "" {
+		ref := strings.TrimPrefix(s.Ref, "#/definitions/")
+		if s = v.definitions[ref]; s == nil {
+			*problems = append(*problems, Problem{
+				Field:   field,
+				Message: fmt.Sprintf("cannot check the value, the definition %s is not bundled", ref),
+				Warning: true,
+			})
+			return
+		}
+	}
+
+	switch s.Type {
+	case "":
+		return
+
+	case "object":
+		m, ok := value.(map[string]any)
+		if !ok {
+			v.mismatch(problems, field, "object", value)
+			return
+		}
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := joinField(field, k)
+			if s.AdditionalProperties != nil {
+				v.walk(problems, child, s.AdditionalProperties, m[k])
+				continue
+			}
+			prop, ok := s.Properties[k]
+			if !ok {
+				*problems = append(*problems, Problem{Field: child, Message: "unknown field"})
+				continue
+			}
+			v.walk(problems, child, prop, m[k])
+		}
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			v.mismatch(problems, field, "array", value)
+			return
+		}
+		for i, item := range items {
+			v.walk(problems, fmt.Sprintf("%s[%d]", field, i), s.Items, item)
+		}
+
+	case "string":
+		switch value.(type) {
+		case string:
+		case float64:
+			// Quantities and ports accept numbers too.
+			if s.Format != "quantity" && s.Format != "int-or-string" {
+				v.mismatch(problems, field, "string", value)
+			}
+		default:
+			v.mismatch(problems, field, "string", value)
+		}
+
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			v.mismatch(problems, field, "integer", value)
+		}
+
+	case "number":
+		if _, ok := value.(float64); !ok {
+			v.mismatch(problems, field, "number", value)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.mismatch(problems, field, "boolean", value)
+		}
+	}
+}
+
+func (v *Validator) mismatch(problems *[]Problem, field, expected string, value any) {
+	*problems = append(*problems, Problem{
+		Field:   field,
+		Message: fmt.Sprintf("expected %s, got %s", expected, jsonType(value)),
+	})
+}
+
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+var reIdentifier = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$-]*$`)
+
+func joinField(field, key string) string {
+	if !reIdentifier.MatchString(key) {
+		return field + "[" + strconv.Quote(key) + "]"
+	}
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
+
+func parseVersion(version string) ([2]int, error) {
+	major, minor, ok := strings.Cut(version, ".")
+	if !ok {
+		return [2]int{}, errors.Errorf("invalid Kubernetes version %q, use the format 1.34", version)
+	}
+	x, err := strconv.Atoi(major)
+	if err != nil {
+		return [2]int{}, errors.Errorf("invalid Kubernetes version %q, use the format 1.34", version)
+	}
+	y, err := strconv.Atoi(minor)
+	if err != nil {
+		return [2]int{}, errors.Errorf("invalid Kubernetes version %q, use the format 1.34", version)
+	}
+	return [2]int{x, y}, nil
+}
+
+func compareVersions(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+	if va[0] != vb[0] {
+		return va[0] - vb[0]
+	}
+	return va[1] - vb[1]
+}
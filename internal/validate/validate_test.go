@@ -0,0 +1,220 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func deployment(spec map[string]any) map[string]any {
+	return map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "foo"},
+		"spec":       spec,
+	}
+}
+
+func service(port map[string]any) map[string]any {
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]any{"name": "foo"},
+		"spec": map[string]any{
+			"ports": []any{port},
+		},
+	}
+}
+
+func resources(limits map[string]any) map[string]any {
+	return deployment(map[string]any{
+		"template": map[string]any{
+			"spec": map[string]any{
+				"containers": []any{
+					map[string]any{
+						"name":      "foo",
+						"resources": map[string]any{"limits": limits},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		obj      map[string]any
+		expected []Problem
+	}{
+		{
+			name: "valid",
+			obj:  deployment(map[string]any{"replicas": 3.0, "paused": false}),
+		},
+		{
+			name:     "missing kind",
+			obj:      map[string]any{"apiVersion": "v1"},
+			expected: []Problem{{Message: "missing apiVersion or kind"}},
+		},
+		{
+			name: "unknown field",
+			obj:  deployment(map[string]any{"replica": 3.0, "foo.bar": true}),
+			expected: []Problem{
+				{Field: "spec.replica", Message: "unknown field"},
+				{Field: `spec["foo.bar"]`, Message: "unknown field"},
+			},
+		},
+		{
+			name: "type mismatch",
+			obj:  deployment(map[string]any{"replicas": "3", "paused": "false", "selector": []any{}}),
+			expected: []Problem{
+				{Field: "spec.paused", Message: "expected boolean, got string"},
+				{Field: "spec.replicas", Message: "expected integer, got string"},
+				{Field: "spec.selector", Message: "expected object, got array"},
+			},
+		},
+		{
+			name:     "fractional integer",
+			obj:      deployment(map[string]any{"replicas": 1.5}),
+			expected: []Problem{{Field: "spec.replicas", Message: "expected integer, got number"}},
+		},
+		{
+			name: "int-or-string as integer",
+			obj:  service(map[string]any{"port": 80.0, "targetPort": 8080.0}),
+		},
+		{
+			name: "int-or-string as string",
+			obj:  service(map[string]any{"port": 80.0, "targetPort": "http"}),
+		},
+		{
+			name:     "int-or-string as boolean",
+			obj:      service(map[string]any{"port": 80.0, "targetPort": true}),
+			expected: []Problem{{Field: "spec.ports[0].targetPort", Message: "expected string, got boolean"}},
+		},
+		{
+			name:     "number in a plain string",
+			obj:      service(map[string]any{"port": 80.0, "name": 80.0}),
+			expected: []Problem{{Field: "spec.ports[0].name", Message: "expected string, got integer"}},
+		},
+		{
+			name: "quantities",
+			obj:  resources(map[string]any{"cpu": 1.0, "memory": "512Mi"}),
+		},
+		{
+			name:     "invalid quantity",
+			obj:      resources(map[string]any{"cpu": []any{}}),
+			expected: []Problem{{Field: "spec.template.spec.containers[0].resources.limits.cpu", Message: "expected string, got array"}},
+		},
+		{
+			name: "removed apiVersion",
+			obj:  map[string]any{"apiVersion": "extensions/v1beta1", "kind": "Ingress"},
+			expected: []Problem{
+				{Message: "apiVersion extensions/v1beta1 of Ingress was removed in Kubernetes 1.22, use networking.k8s.io/v1 instead"},
+			},
+		},
+		{
+			name: "removed apiVersion of any kind",
+			obj:  map[string]any{"apiVersion": "apps/v1beta2", "kind": "StatefulSet"},
+			expected: []Problem{
+				{Message: "apiVersion apps/v1beta2 of StatefulSet was removed in Kubernetes 1.16, use apps/v1 instead"},
+			},
+		},
+		{
+			name: "deprecated custom resource",
+			obj:  map[string]any{"apiVersion": "aadpodidentity.k8s.io/v1", "kind": "AzureIdentity"},
+			expected: []Problem{
+				{Message: "apiVersion aadpodidentity.k8s.io/v1 of AzureIdentity is deprecated, use Microsoft Entra Workload ID instead", Warning: true},
+			},
+		},
+		{
+			name: "deprecated custom resource binding",
+			obj:  map[string]any{"apiVersion": "aadpodidentity.k8s.io/v1", "kind": "AzureIdentityBinding"},
+			expected: []Problem{
+				{Message: "apiVersion aadpodidentity.k8s.io/v1 of AzureIdentityBinding is deprecated, use Microsoft Entra Workload ID instead", Warning: true},
+			},
+		},
+		{
+			name:     "unknown kind",
+			obj:      map[string]any{"apiVersion": "apps/v1", "kind": "Foo"},
+			expected: []Problem{{Message: "unknown kind Foo in apps/v1 for Kubernetes 1.34"}},
+		},
+		{
+			name:     "unknown kind in the core group",
+			obj:      map[string]any{"apiVersion": "v1", "kind": "Foo"},
+			expected: []Problem{{Message: "unknown kind Foo in v1 for Kubernetes 1.34"}},
+		},
+		{
+			name: "custom resource",
+			obj:  map[string]any{"apiVersion": "monitoring.coreos.com/v1", "kind": "ServiceMonitor", "spec": map[string]any{"foo": "bar"}},
+		},
+	}
+
+	v, err := New("1.34")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := v.Validate(test.obj)
+			if !reflect.DeepEqual(problems, test.expected) {
+				t.Errorf("unexpected problems:\n got: %+v\nwant: %+v", problems, test.expected)
+			}
+		})
+	}
+}
+
+func TestDeprecationBeforeRemoval(t *testing.T) {
+	v := &Validator{version: "1.21"}
+	problem, ok := v.deprecation("extensions/v1beta1", "Ingress")
+	if !ok {
+		t.Fatal("expected a deprecation")
+	}
+	expected := Problem{
+		Message: "apiVersion extensions/v1beta1 of Ingress is deprecated and will be removed in Kubernetes 1.22, use networking.k8s.io/v1 instead",
+		Warning: true,
+	}
+	if problem != expected {
+		t.Errorf("unexpected problem: %+v", problem)
+	}
+
+	if _, ok := v.deprecation("networking.k8s.io/v1beta1", "NetworkPolicy"); ok {
+		t.Error("expected no deprecation for a kind outside the list")
+	}
+}
+
+func TestValidateMissingDefinition(t *testing.T) {
+	v := &Validator{
+		version: "1.34",
+		definitions: map[string]*schema{
+			"foo": {
+				Type: "object",
+				Properties: map[string]*schema{
+					"apiVersion": {Type: "string"},
+					"kind":       {Type: "string"},
+					"spec":       {Ref: "#/definitions/bar"},
+				},
+			},
+		},
+		kinds:  map[string]string{"example.com/v1/Foo": "foo"},
+		groups: map[string]bool{"example.com": true},
+	}
+	problems := v.Validate(map[string]any{
+		"apiVersion": "example.com/v1",
+		"kind":       "Foo",
+		"spec":       map[string]any{"foo": "bar"},
+	})
+	expected := []Problem{{Field: "spec", Message: "cannot check the value, the definition bar is not bundled", Warning: true}}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("unexpected problems: %+v", problems)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	if err := CheckVersion("1.34"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, version := range []string{"1.20", "1.35", "2.0", "1", "latest"} {
+		if err := CheckVersion(version); err == nil {
+			t.Errorf("expected an error for version %q", version)
+		}
+	}
+}
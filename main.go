@@ -5,6 +5,7 @@ import (
 	"github.com/joho/godotenv"
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/altipla-consulting/wave/internal/amazon"
 	"github.com/altipla-consulting/wave/internal/containerapps"
 	"github.com/altipla-consulting/wave/internal/debug"
 	"github.com/altipla-consulting/wave/internal/workerpools"
@@ -37,6 +38,7 @@ func init() {
 	cmdRoot.AddCommand(cmdPages)
 	cmdRoot.AddCommand(cmdPreview)
 	cmdRoot.AddCommand(cmdVersion)
+	cmdRoot.AddCommand(amazon.Cmd)
 	cmdRoot.AddCommand(debug.Cmd)
 	cmdRoot.AddCommand(containerapps.Cmd)
 	cmdRoot.AddCommand(workerpools.Cmd)
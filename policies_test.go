@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "policy.jsonnet")
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func testDeployment(container map[string]any) *k8sList {
+	return &k8sList{
+		Items: []any{
+			map[string]any{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]any{"name": "foo"},
+				"spec": map[string]any{
+					"template": map[string]any{
+						"spec": map[string]any{
+							"containers": []any{container},
+						},
+					},
+				},
+			},
+		},
+		paths: []string{"foo"},
+	}
+}
+
+func TestCheckPolicyDefaults(t *testing.T) {
+	policy := writePolicy(t, `import 'wave/policies.jsonnet'`)
+	opts := RunOptions{Version: goldenVersion}
+
+	valid := testDeployment(map[string]any{
+		"name":           "foo",
+		"image":          "eu.gcr.io/foo/bar:" + goldenVersion,
+		"resources":      map[string]any{"requests": map[string]any{"cpu": "10m"}},
+		"readinessProbe": map[string]any{},
+		"livenessProbe":  map[string]any{},
+	})
+	if err := checkPolicy(context.Background(), policy, valid, opts); err != nil {
+		t.Errorf("valid deployment: %s", err)
+	}
+
+	invalid := testDeployment(map[string]any{
+		"name":  "foo",
+		"image": "foo:latest",
+	})
+	if err := checkPolicy(context.Background(), policy, invalid, opts); err == nil {
+		t.Errorf("invalid deployment should fail the policy")
+	}
+}
+
+func TestCheckPolicyVersionTag(t *testing.T) {
+	policy := writePolicy(t, `(import 'wave/policies.jsonnet') { probes:: null, resourceRequests:: null }`)
+	opts := RunOptions{Version: goldenVersion}
+
+	tests := []struct {
+		image string
+		valid bool
+	}{
+		{"foo:" + goldenVersion, true},
+		{"localhost:5000/foo:" + goldenVersion, true},
+		{"foo@sha256:0123456789abcdef", true},
+		{"foo", false},
+		{"foo:latest", false},
+		{"foo:v1", false},
+		{"localhost:5000/foo", false},
+		{"foo:" + goldenVersion + "-old", false},
+	}
+	for _, test := range tests {
+		list := testDeployment(map[string]any{
+			"name":  "foo",
+			"image": test.image,
+		})
+		err := checkPolicy(context.Background(), policy, list, opts)
+		if test.valid && err != nil {
+			t.Errorf("image %s should pass the policy: %s", test.image, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("image %s should fail the policy", test.image)
+		}
+	}
+}
+
+func TestCheckPolicyHideRule(t *testing.T) {
+	policy := writePolicy(t, `(import 'wave/policies.jsonnet') { probes:: null, resourceRequests:: null }`)
+	list := testDeployment(map[string]any{
+		"name":  "foo",
+		"image": "foo:" + goldenVersion,
+	})
+	if err := checkPolicy(context.Background(), policy, list, RunOptions{Version: goldenVersion}); err != nil {
+		t.Errorf("hidden rules should not run: %s", err)
+	}
+}